@@ -0,0 +1,150 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	kindMessage = "message"
+	kindAction  = "action"
+	kindJoin    = "join"
+	kindPart    = "part"
+	kindStatus  = "status"
+	kindEvents  = "events"
+	kindModule  = "module"
+
+	apiTokenKey = "apiToken"
+
+	noTokensErrMsg     = "no api tokens are configured"
+	missingTokenErrMsg = "missing api token"
+	invalidTokenErrMsg = "invalid api token"
+	forbiddenErrMsg    = "api token is not allowed to do this"
+)
+
+type ApiToken struct {
+	Name         string   `validate:"required"`
+	Token        string   `validate:"required,min=16"`
+	Networks     []string `validate:"dive,required"`
+	Destinations []string `validate:"required,dive,required"`
+	Kinds        []string `validate:"required,dive,oneof=message action join part status events module"`
+}
+
+// AllowedKind is for requests that aren't aimed at a destination, such as
+// reading the status.
+func (t *ApiToken) AllowedKind(kind string) bool {
+	return slices.Contains(t.Kinds, kind)
+}
+
+// Allowed checks a request aimed at dest on a network, which is allowed when
+// the token has no networks listed.
+func (t *ApiToken) Allowed(kind, network, dest string) bool {
+	if !t.AllowedKind(kind) || dest == "" {
+		return false
+	}
+
+	return t.allowedNetwork(network) && matchMask(dest, t.Destinations)
+}
+
+// AllowedEvent checks an event for the event stream. Events that aren't
+// aimed at a channel, such as QUIT, NICK or AWAY, carry no dest and can't be
+// scoped, so only tokens allowed every destination see them.
+func (t *ApiToken) AllowedEvent(network, dest string) bool {
+	if dest != "" {
+		return t.Allowed(kindEvents, network, dest)
+	}
+
+	return t.AllowedKind(kindEvents) && t.allowedNetwork(network) && slices.Contains(t.Destinations, "*")
+}
+
+func (t *ApiToken) allowedNetwork(network string) bool {
+	return len(t.Networks) == 0 || slices.Contains(t.Networks, network)
+}
+
+func findApiToken(tokens []ApiToken, token string) (ApiToken, bool) {
+	for _, t := range tokens {
+		if subtle.ConstantTimeCompare([]byte(t.Token), []byte(token)) == 1 {
+			return t, true
+		}
+	}
+
+	return ApiToken{}, false
+}
+
+// messageKind checks every line, as each is sent as its own message.
+func messageKind(msg string) string {
+	for _, line := range strings.Split(msg, "\n") {
+		if strings.HasPrefix(strings.TrimLeft(line, "\r"), "\x01ACTION") {
+			return kindAction
+		}
+	}
+
+	return kindMessage
+}
+
+func createAuthMiddleware(cm *ConfigManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cfg := cm.Config()
+		tokens := cfg.ApiTokens
+		if len(tokens) == 0 {
+			if !cfg.ApiNoAuth {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": noTokensErrMsg})
+				return
+			}
+
+			c.Next()
+			return
+		}
+
+		token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": missingTokenErrMsg})
+			return
+		}
+
+		t, ok := findApiToken(tokens, token)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": invalidTokenErrMsg})
+			return
+		}
+
+		c.Set(apiTokenKey, t)
+		c.Next()
+	}
+}
+
+func contextToken(c *gin.Context) *ApiToken {
+	v, ok := c.Get(apiTokenKey)
+	if !ok {
+		return nil
+	}
+
+	t := v.(ApiToken)
+	return &t
+}
+
+func authorised(c *gin.Context, kind, network, dest string) bool {
+	t := contextToken(c)
+	if t == nil || t.Allowed(kind, network, dest) {
+		return true
+	}
+
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"token": t.Name, "kind": kind, "network": network, "dest": dest, "error": forbiddenErrMsg})
+
+	return false
+}
+
+func authorisedKind(c *gin.Context, kind string) bool {
+	t := contextToken(c)
+	if t == nil || t.AllowedKind(kind) {
+		return true
+	}
+
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"token": t.Name, "kind": kind, "error": forbiddenErrMsg})
+
+	return false
+}
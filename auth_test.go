@@ -0,0 +1,184 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApiTokenAllowed(t *testing.T) {
+	token := ApiToken{
+		Name:         "ci",
+		Token:        "0123456789abcdef",
+		Networks:     []string{"libera"},
+		Destinations: []string{"#builds", "#team-*"},
+		Kinds:        []string{kindMessage, kindJoin},
+	}
+
+	cases := map[string]struct {
+		kind     string
+		network  string
+		dest     string
+		expected bool
+	}{
+		"allowed destination": {
+			kind:     kindMessage,
+			dest:     "#builds",
+			expected: true,
+		},
+		"destination case": {
+			kind:     kindMessage,
+			dest:     "#Builds",
+			expected: true,
+		},
+		"wildcard destination": {
+			kind:     kindJoin,
+			dest:     "#team-ops",
+			expected: true,
+		},
+		"other destination": {
+			kind:     kindMessage,
+			dest:     "#general",
+			expected: false,
+		},
+		"other kind": {
+			kind:     kindAction,
+			dest:     "#builds",
+			expected: false,
+		},
+		"no destination": {
+			kind:     kindMessage,
+			dest:     "",
+			expected: false,
+		},
+		"other network": {
+			kind:     kindMessage,
+			network:  "oftc",
+			dest:     "#builds",
+			expected: false,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			network := tc.network
+			if network == "" {
+				network = "libera"
+			}
+
+			assert.Equal(t, tc.expected, token.Allowed(tc.kind, network, tc.dest))
+		})
+	}
+}
+
+func TestApiTokenAllowedAnyNetwork(t *testing.T) {
+	token := ApiToken{Destinations: []string{"#builds"}, Kinds: []string{kindMessage, kindStatus}}
+
+	assert.True(t, token.Allowed(kindMessage, "libera", "#builds"))
+	assert.True(t, token.Allowed(kindMessage, "oftc", "#builds"))
+	assert.True(t, token.AllowedKind(kindStatus))
+	assert.False(t, token.AllowedKind(kindEvents))
+}
+
+func TestMessageKind(t *testing.T) {
+	assert.Equal(t, kindMessage, messageKind("hello"))
+	assert.Equal(t, kindAction, messageKind("\x01ACTION waves\x01"))
+	assert.Equal(t, kindAction, messageKind("hello\n\x01ACTION waves\x01"))
+	assert.Equal(t, kindAction, messageKind("hello\r\n\x01ACTION waves\x01"))
+	assert.Equal(t, kindMessage, messageKind("not an \x01ACTION"))
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tokens := []ApiToken{{
+		Name:         "ci",
+		Token:        "0123456789abcdef",
+		Destinations: []string{"#builds"},
+		Kinds:        []string{kindMessage},
+	}}
+
+	cases := map[string]struct {
+		tokens   []ApiToken
+		noAuth   bool
+		header   string
+		dest     string
+		expected int
+	}{
+		"no tokens configured": {
+			tokens:   nil,
+			header:   "",
+			dest:     "#general",
+			expected: http.StatusUnauthorized,
+		},
+		"authentication disabled": {
+			tokens:   nil,
+			noAuth:   true,
+			header:   "",
+			dest:     "#general",
+			expected: http.StatusOK,
+		},
+		"authentication disabled with tokens": {
+			tokens:   tokens,
+			noAuth:   true,
+			header:   "",
+			dest:     "#builds",
+			expected: http.StatusUnauthorized,
+		},
+		"missing token": {
+			tokens:   tokens,
+			header:   "",
+			dest:     "#builds",
+			expected: http.StatusUnauthorized,
+		},
+		"invalid token": {
+			tokens:   tokens,
+			header:   "Bearer fedcba9876543210",
+			dest:     "#builds",
+			expected: http.StatusUnauthorized,
+		},
+		"valid token": {
+			tokens:   tokens,
+			header:   "Bearer 0123456789abcdef",
+			dest:     "#builds",
+			expected: http.StatusOK,
+		},
+		"forbidden destination": {
+			tokens:   tokens,
+			header:   "Bearer 0123456789abcdef",
+			dest:     "#general",
+			expected: http.StatusForbidden,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			cm := NewConfigManager()
+			cm.MergedConfig = &Config{ApiTokens: tc.tokens, ApiNoAuth: tc.noAuth}
+
+			r := gin.New()
+			r.Use(createAuthMiddleware(cm))
+			r.GET("/", func(c *gin.Context) {
+				if !authorised(c, kindMessage, defaultNetwork, c.Query("dest")) {
+					return
+				}
+
+				c.Status(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/?dest="+url.QueryEscape(tc.dest), nil)
+			if tc.header != "" {
+				req.Header.Set("Authorization", tc.header)
+			}
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expected, w.Code)
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strings"
+)
+
+const (
+	capMessageTags = "message-tags"
+	capEchoMessage = "echo-message"
+)
+
+// requestedCaps returns a new list for each network, since ircevent adds
+// to it (sasl) when connecting.
+func requestedCaps() []string {
+	return []string{
+		"server-time",
+		capMessageTags,
+		"account-tag",
+		capEchoMessage,
+		"labeled-response",
+		"batch",
+		"away-notify",
+		"extended-join",
+		capMultiline,
+	}
+}
+
+func clientTags(tags map[string]string) map[string]string {
+	out := map[string]string{}
+
+	for k, v := range tags {
+		if strings.HasPrefix(k, "+") {
+			out[k] = v
+		}
+	}
+
+	return out
+}
+
+func (n *Network) HasCap(c string) bool {
+	_, ok := n.irccon.AcknowledgedCaps()[c]
+	return ok
+}
+
+func (n *Network) outgoingTags(tags map[string]string) map[string]string {
+	if !n.HasCap(capMessageTags) {
+		return nil
+	}
+
+	out := dropLongTags(clientTags(tags))
+	if len(out) == 0 {
+		return nil
+	}
+
+	return out
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ergochat/irc-go/ircmsg"
+	"github.com/gowon-irc/gowon/pkg/message"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientTags(t *testing.T) {
+	cases := map[string]struct {
+		tags     map[string]string
+		expected map[string]string
+	}{
+		"reply tag": {
+			tags:     map[string]string{"+draft/reply": "abc"},
+			expected: map[string]string{"+draft/reply": "abc"},
+		},
+		"server tags removed": {
+			tags:     map[string]string{"msgid": "abc", "account": "nick", "+draft/react": "👍"},
+			expected: map[string]string{"+draft/react": "👍"},
+		},
+		"no tags": {
+			tags:     nil,
+			expected: map[string]string{},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, clientTags(tc.tags))
+		})
+	}
+}
+
+func TestRequestedCapsPerNetwork(t *testing.T) {
+	s := newTestIrcServer(t, "")
+	first := newTestNetwork(t, s, &Config{Password: "hunter2"})
+	second := newTestNetwork(t, s, &Config{})
+
+	first.irccon.RequestCaps = append(first.irccon.RequestCaps, "sasl")
+	first.irccon.RequestCaps[0] = "changed"
+
+	assert.NotContains(t, second.irccon.RequestCaps, "sasl")
+	assert.NotContains(t, second.irccon.RequestCaps, "changed")
+	assert.Equal(t, requestedCaps(), second.irccon.RequestCaps)
+}
+
+func TestOutgoingTags(t *testing.T) {
+	cases := map[string]struct {
+		caps     string
+		expected map[string]string
+	}{
+		"message-tags acknowledged": {
+			caps:     capMessageTags,
+			expected: map[string]string{"+draft/reply": "abc"},
+		},
+		"message-tags not supported": {
+			caps:     "",
+			expected: map[string]string{},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			s := newTestIrcServer(t, tc.caps)
+			n := newTestNetwork(t, s, &Config{})
+			connectTestNetwork(t, n)
+			s.expect(t, "JOIN")
+
+			n.SendMessage("#gowon", "hello", map[string]string{"+draft/reply": "abc", "msgid": "xyz"})
+
+			// tagged lines start with the tags, so look at every line
+			m, _ := ircmsg.ParseLine(s.expect(t, ""))
+			for m.Command != "PRIVMSG" {
+				m, _ = ircmsg.ParseLine(s.expect(t, ""))
+			}
+
+			assert.Equal(t, []string{"#gowon", "hello"}, m.Params)
+			assert.Equal(t, tc.expected, m.AllTags())
+		})
+	}
+}
+
+func TestEchoMessageSuppressed(t *testing.T) {
+	s := newTestIrcServer(t, capEchoMessage)
+	n := newTestNetwork(t, s, &Config{})
+
+	received := make(chan *message.Message, 10)
+
+	cr := &CommandRouter{}
+	cr.AddInternal("rev", "", func(in *message.Message) string {
+		received <- in
+		return "olleh"
+	})
+	n.irccon.AddCallback("PRIVMSG", createIrcHandler(n, cr, nil))
+
+	connectTestNetwork(t, n)
+	s.expect(t, "JOIN")
+	assert.Contains(t, n.irccon.AcknowledgedCaps(), capEchoMessage)
+
+	// the server echoes this back from the bot's own nick
+	n.SendMessage("#gowon", ".rev mine", nil)
+	s.expect(t, "PRIVMSG #gowon")
+
+	s.send(":nick!user@host PRIVMSG #gowon :.rev theirs")
+
+	select {
+	case m := <-received:
+		assert.Equal(t, "theirs", m.Args)
+	case <-time.After(5 * time.Second):
+		t.Fatal("command from another user was not routed")
+	}
+
+	// the reply is echoed as well, and isn't routed either
+	m, err := ircmsg.ParseLine(s.expect(t, "PRIVMSG"))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"#gowon", "olleh"}, m.Params)
+	time.Sleep(100 * time.Millisecond)
+	assert.Len(t, received, 0)
+}
@@ -0,0 +1,503 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ergochat/irc-go/ircevent"
+	"github.com/ergochat/irc-go/ircmsg"
+	"github.com/gowon-irc/gowon/pkg/message"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	invalidChannelErrMsg = "invalid channel name"
+	notAdminErrMsg       = "only admins can run this command"
+
+	inviteIgnore   = "ignore"
+	inviteAdmins   = "admins"
+	inviteEveryone = "everyone"
+)
+
+type ChannelManager struct {
+	irccon     *ircevent.Connection
+	mu         sync.Mutex
+	channels   map[string]bool
+	keys       map[string]string
+	strip      map[string]bool
+	colourless map[string]bool
+	// the channels the server says the bot is in, which can differ from
+	// channels after a kick, a ban or a failed join
+	present map[string]bool
+}
+
+func NewChannelManager(irccon *ircevent.Connection) *ChannelManager {
+	return &ChannelManager{
+		irccon:     irccon,
+		channels:   make(map[string]bool),
+		keys:       make(map[string]string),
+		strip:      make(map[string]bool),
+		colourless: make(map[string]bool),
+		present:    make(map[string]bool),
+	}
+}
+
+var joinErrors = []string{
+	ircevent.ERR_CHANNELISFULL,
+	ircevent.ERR_INVITEONLYCHAN,
+	ircevent.ERR_BANNEDFROMCHAN,
+	ircevent.ERR_BADCHANNELKEY,
+}
+
+func normaliseChannel(channel string) string {
+	if channel != "" && !strings.ContainsAny(channel[:1], "#&") {
+		return "#" + channel
+	}
+
+	return channel
+}
+
+func checkChannel(channel string) error {
+	re := regexp.MustCompile(ircChannelRegex)
+	if !re.MatchString(channel) {
+		return errors.New(invalidChannelErrMsg)
+	}
+
+	return nil
+}
+
+func (chm *ChannelManager) SetKeys(keys map[string]string) {
+	chm.mu.Lock()
+	defer chm.mu.Unlock()
+
+	for c, k := range keys {
+		chm.keys[strings.ToLower(c)] = k
+	}
+}
+
+func (chm *ChannelManager) key(channel string) string {
+	chm.mu.Lock()
+	defer chm.mu.Unlock()
+
+	return chm.keys[strings.ToLower(channel)]
+}
+
+func (chm *ChannelManager) SetStripFormatting(channels []string) {
+	chm.mu.Lock()
+	defer chm.mu.Unlock()
+
+	chm.strip = make(map[string]bool)
+	for _, c := range channels {
+		chm.strip[strings.ToLower(c)] = true
+	}
+}
+
+func (chm *ChannelManager) StripFormatting(channel string) bool {
+	chm.mu.Lock()
+	defer chm.mu.Unlock()
+
+	c := strings.ToLower(channel)
+	return chm.strip[c] || chm.colourless[c]
+}
+
+func noColourMode(modes string, current bool) bool {
+	adding := true
+
+	for _, m := range modes {
+		switch m {
+		case '+':
+			adding = true
+		case '-':
+			adding = false
+		case 'c':
+			current = adding
+		}
+	}
+
+	return current
+}
+
+func (chm *ChannelManager) SetModes(channel, modes string) {
+	chm.mu.Lock()
+	defer chm.mu.Unlock()
+
+	c := strings.ToLower(channel)
+	chm.colourless[c] = noColourMode(modes, chm.colourless[c])
+}
+
+func (chm *ChannelManager) setPresent(channel string, present bool) {
+	chm.mu.Lock()
+	defer chm.mu.Unlock()
+
+	if present {
+		chm.present[strings.ToLower(channel)] = true
+	} else {
+		delete(chm.present, strings.ToLower(channel))
+	}
+}
+
+// In reports whether the server has confirmed the bot is in channel.
+func (chm *ChannelManager) In(channel string) bool {
+	chm.mu.Lock()
+	defer chm.mu.Unlock()
+
+	return chm.present[strings.ToLower(channel)]
+}
+
+// HandleConnect forgets the channels of the previous connection.
+func (chm *ChannelManager) HandleConnect(event ircmsg.Message) {
+	chm.mu.Lock()
+	chm.present = make(map[string]bool)
+	chm.mu.Unlock()
+}
+
+func (chm *ChannelManager) HandlePart(event ircmsg.Message) {
+	if event.Nick() != chm.irccon.CurrentNick() || len(event.Params) == 0 {
+		return
+	}
+
+	chm.setPresent(event.Params[0], false)
+}
+
+func (chm *ChannelManager) HandleKick(event ircmsg.Message) {
+	if len(event.Params) < 2 || event.Params[1] != chm.irccon.CurrentNick() {
+		return
+	}
+
+	log.Printf("Kicked from %s by %s", event.Params[0], event.Source)
+	chm.setPresent(event.Params[0], false)
+}
+
+func (chm *ChannelManager) HandleJoinError(event ircmsg.Message) {
+	if len(event.Params) < 2 {
+		return
+	}
+
+	log.Printf("Could not join %s: %s", event.Params[1], event.Params[len(event.Params)-1])
+	chm.setPresent(event.Params[1], false)
+}
+
+func (chm *ChannelManager) HandleJoin(event ircmsg.Message) {
+	if event.Nick() != chm.irccon.CurrentNick() || len(event.Params) == 0 {
+		return
+	}
+
+	chm.mu.Lock()
+	delete(chm.colourless, strings.ToLower(event.Params[0]))
+	chm.present[strings.ToLower(event.Params[0])] = true
+	chm.mu.Unlock()
+
+	if err := chm.irccon.Send("MODE", event.Params[0]); err != nil {
+		log.Println(err)
+	}
+}
+
+func (chm *ChannelManager) HandleMode(event ircmsg.Message) {
+	if len(event.Params) < 2 || checkChannel(event.Params[0]) != nil {
+		return
+	}
+
+	chm.SetModes(event.Params[0], event.Params[1])
+}
+
+func (chm *ChannelManager) HandleChannelModeIs(event ircmsg.Message) {
+	if len(event.Params) < 3 {
+		return
+	}
+
+	chm.SetModes(event.Params[1], event.Params[2])
+}
+
+func (chm *ChannelManager) joinParams(channel string) []string {
+	if k := chm.key(channel); k != "" {
+		return []string{channel, k}
+	}
+
+	return []string{channel}
+}
+
+func (chm *ChannelManager) Join(channel, key string) error {
+	if err := checkChannel(channel); err != nil {
+		return err
+	}
+
+	if key != "" {
+		chm.SetKeys(map[string]string{channel: key})
+	}
+
+	chm.mu.Lock()
+	chm.removeChannel(channel)
+	chm.channels[channel] = true
+	chm.mu.Unlock()
+
+	if !chm.irccon.Connected() {
+		return nil
+	}
+
+	return chm.irccon.Send("JOIN", chm.joinParams(channel)...)
+}
+
+func (chm *ChannelManager) Part(channel, reason string) error {
+	if err := checkChannel(channel); err != nil {
+		return err
+	}
+
+	chm.mu.Lock()
+	chm.removeChannel(channel)
+	chm.mu.Unlock()
+
+	if !chm.irccon.Connected() {
+		return nil
+	}
+
+	if reason != "" {
+		return chm.irccon.Send("PART", channel, reason)
+	}
+
+	return chm.irccon.Part(channel)
+}
+
+// removeChannel forgets channel however it was cased when it was joined.
+// chm.mu must be held.
+func (chm *ChannelManager) removeChannel(channel string) {
+	for c := range chm.channels {
+		if strings.EqualFold(c, channel) {
+			delete(chm.channels, c)
+		}
+	}
+}
+
+func (chm *ChannelManager) Joined(channel string) bool {
+	chm.mu.Lock()
+	defer chm.mu.Unlock()
+
+	for c := range chm.channels {
+		if strings.EqualFold(c, channel) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (chm *ChannelManager) Channels() []string {
+	chm.mu.Lock()
+	defer chm.mu.Unlock()
+
+	out := []string{}
+	for c := range chm.channels {
+		out = append(out, c)
+	}
+
+	sort.Strings(out)
+
+	return out
+}
+
+func (chm *ChannelManager) JoinAll() {
+	for _, c := range chm.Channels() {
+		if err := chm.irccon.Send("JOIN", chm.joinParams(c)...); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+func (chm *ChannelManager) Sync(old, new []string) {
+	join, part := diffChannels(old, new)
+
+	for _, c := range join {
+		if chm.Joined(c) {
+			continue
+		}
+
+		log.Printf("Joining %s", c)
+		if err := chm.Join(c, ""); err != nil {
+			log.Println(err)
+		}
+	}
+
+	for _, c := range part {
+		log.Printf("Parting %s", c)
+		if err := chm.Part(c, ""); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+func diffChannels(old, new []string) (join, part []string) {
+	join, part = []string{}, []string{}
+
+	contains := func(ss []string, c string) bool {
+		for _, s := range ss {
+			if strings.EqualFold(s, c) {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, c := range new {
+		if !contains(old, c) && !contains(join, c) {
+			join = append(join, c)
+		}
+	}
+
+	for _, c := range old {
+		if !contains(new, c) && !contains(part, c) {
+			part = append(part, c)
+		}
+	}
+
+	return join, part
+}
+
+func hostmaskRegex(mask string) string {
+	parts := strings.Split(mask, "*")
+	for n, p := range parts {
+		parts[n] = strings.ReplaceAll(regexp.QuoteMeta(p), `\?`, ".")
+	}
+
+	return "(?i)^" + strings.Join(parts, ".*") + "$"
+}
+
+func matchMask(value string, masks []string) bool {
+	for _, mask := range masks {
+		if m, _ := regexp.MatchString(hostmaskRegex(mask), value); m {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isAdmin(source string, admins []string) bool {
+	return matchMask(source, admins)
+}
+
+func createChannelCommandFunc(admins []string, action string, networks Networks, f func(n *Network, channel, arg string) error) func(in *message.Message) string {
+	return func(in *message.Message) string {
+		if !isAdmin(in.Source, admins) {
+			return fmt.Sprintf("{red}Error: %s{clear}", notAdminErrMsg)
+		}
+
+		fields := strings.Fields(in.Args)
+		if len(fields) == 0 {
+			return fmt.Sprintf("{red}Error: no channel given to %s{clear}", action)
+		}
+
+		n, err := networks.Get(in.Network)
+		if err != nil {
+			return fmt.Sprintf("{red}Error: %s{clear}", message.EscapeTokens(err.Error()))
+		}
+
+		channel := normaliseChannel(fields[0])
+		arg := strings.TrimSpace(strings.TrimPrefix(in.Args, fields[0]))
+
+		if err := f(n, channel, arg); err != nil {
+			return fmt.Sprintf("{red}Error: could not %s %s: %s{clear}", action, message.EscapeTokens(channel), message.EscapeTokens(err.Error()))
+		}
+
+		return fmt.Sprintf("{green}%s{clear}: ok", message.EscapeTokens(channel))
+	}
+}
+
+func acceptInvite(policy, source string, admins []string) bool {
+	switch policy {
+	case inviteEveryone:
+		return true
+	case inviteAdmins:
+		return isAdmin(source, admins)
+	default:
+		return false
+	}
+}
+
+type invitedNetwork struct {
+	Name     string
+	Channels []string
+}
+
+func appendChannel(channels []string, channel string) []string {
+	for _, c := range channels {
+		if strings.EqualFold(c, channel) {
+			return channels
+		}
+	}
+
+	return append(channels, channel)
+}
+
+func dropChannel(channels []string, channel string) []string {
+	out := []string{}
+
+	for _, c := range channels {
+		if !strings.EqualFold(c, channel) {
+			out = append(out, c)
+		}
+	}
+
+	return out
+}
+
+// updateInvites rewrites the channels saved for network in the invites file.
+func updateInvites(filename, network string, f func(channels []string) []string) error {
+	invited := struct {
+		Channels []string         `yaml:",omitempty"`
+		Networks []invitedNetwork `yaml:",omitempty"`
+	}{}
+
+	content, err := os.ReadFile(filename)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if err := yaml.Unmarshal(content, &invited); err != nil {
+		return err
+	}
+
+	if network == defaultNetwork {
+		invited.Channels = f(invited.Channels)
+	} else {
+		found := false
+
+		for n := range invited.Networks {
+			if invited.Networks[n].Name == network {
+				invited.Networks[n].Channels = f(invited.Networks[n].Channels)
+				found = true
+			}
+		}
+
+		if channels := f(nil); !found && len(channels) > 0 {
+			invited.Networks = append(invited.Networks, invitedNetwork{Name: network, Channels: channels})
+		}
+	}
+
+	out, err := yaml.Marshal(&invited)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filename, out, 0o644)
+}
+
+func persistChannel(filename, network, channel string) error {
+	return updateInvites(filename, network, func(channels []string) []string {
+		return appendChannel(channels, channel)
+	})
+}
+
+func forgetChannel(filename, network, channel string) error {
+	if _, err := os.Stat(filename); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	return updateInvites(filename, network, func(channels []string) []string {
+		return dropChannel(channels, channel)
+	})
+}
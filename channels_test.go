@@ -0,0 +1,333 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ergochat/irc-go/ircevent"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffChannels(t *testing.T) {
+	cases := map[string]struct {
+		old  []string
+		new  []string
+		join []string
+		part []string
+	}{
+		"no change": {
+			old:  []string{"#a", "#b"},
+			new:  []string{"#a", "#b"},
+			join: []string{},
+			part: []string{},
+		},
+		"channel added": {
+			old:  []string{"#a"},
+			new:  []string{"#a", "#b"},
+			join: []string{"#b"},
+			part: []string{},
+		},
+		"channel removed": {
+			old:  []string{"#a", "#b"},
+			new:  []string{"#a"},
+			join: []string{},
+			part: []string{"#b"},
+		},
+		"channel replaced": {
+			old:  []string{"#a"},
+			new:  []string{"#b"},
+			join: []string{"#b"},
+			part: []string{"#a"},
+		},
+		"no old channels": {
+			old:  nil,
+			new:  []string{"#a", "#a"},
+			join: []string{"#a"},
+			part: []string{},
+		},
+		"case insensitive": {
+			old:  []string{"#Chat"},
+			new:  []string{"#chat"},
+			join: []string{},
+			part: []string{},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			join, part := diffChannels(tc.old, tc.new)
+
+			assert.Equal(t, tc.join, join)
+			assert.Equal(t, tc.part, part)
+		})
+	}
+}
+
+func TestNormaliseChannel(t *testing.T) {
+	cases := map[string]struct {
+		input    string
+		expected string
+	}{
+		"hash channel": {
+			input:    "#chat",
+			expected: "#chat",
+		},
+		"ampersand channel": {
+			input:    "&chat",
+			expected: "&chat",
+		},
+		"no prefix": {
+			input:    "chat",
+			expected: "#chat",
+		},
+		"empty": {
+			input:    "",
+			expected: "",
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, normaliseChannel(tc.input))
+		})
+	}
+}
+
+func TestIsAdmin(t *testing.T) {
+	cases := map[string]struct {
+		source string
+		admins []string
+		admin  bool
+	}{
+		"exact match": {
+			source: "nick!user@host.com",
+			admins: []string{"nick!user@host.com"},
+			admin:  true,
+		},
+		"wildcard host": {
+			source: "nick!user@user/nick",
+			admins: []string{"*!*@user/nick"},
+			admin:  true,
+		},
+		"single character wildcard": {
+			source: "nick1!user@host.com",
+			admins: []string{"nick?!user@host.com"},
+			admin:  true,
+		},
+		"case insensitive": {
+			source: "Nick!user@host.com",
+			admins: []string{"nick!*@*"},
+			admin:  true,
+		},
+		"regex characters are literal": {
+			source: "nick!user@hostxcom",
+			admins: []string{"nick!user@host.com"},
+			admin:  false,
+		},
+		"no match": {
+			source: "other!user@host.com",
+			admins: []string{"nick!*@*"},
+			admin:  false,
+		},
+		"no admins": {
+			source: "nick!user@host.com",
+			admins: []string{},
+			admin:  false,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.admin, isAdmin(tc.source, tc.admins))
+		})
+	}
+}
+
+func TestAcceptInvite(t *testing.T) {
+	admins := []string{"admin!*@*"}
+
+	cases := map[string]struct {
+		policy string
+		source string
+		accept bool
+	}{
+		"ignore from admin": {
+			policy: inviteIgnore,
+			source: "admin!user@host",
+			accept: false,
+		},
+		"admins from admin": {
+			policy: inviteAdmins,
+			source: "admin!user@host",
+			accept: true,
+		},
+		"admins from user": {
+			policy: inviteAdmins,
+			source: "user!user@host",
+			accept: false,
+		},
+		"everyone from user": {
+			policy: inviteEveryone,
+			source: "user!user@host",
+			accept: true,
+		},
+		"no policy": {
+			policy: "",
+			source: "admin!user@host",
+			accept: false,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.accept, acceptInvite(tc.policy, tc.source, admins))
+		})
+	}
+}
+
+func TestNoColourMode(t *testing.T) {
+	cases := map[string]struct {
+		modes    string
+		current  bool
+		expected bool
+	}{
+		"set": {
+			modes:    "+nc",
+			expected: true,
+		},
+		"unset": {
+			modes:    "-c",
+			current:  true,
+			expected: false,
+		},
+		"unchanged": {
+			modes:    "+nt-s",
+			current:  true,
+			expected: true,
+		},
+		"set then unset": {
+			modes:    "+c-c",
+			expected: false,
+		},
+		"mixed with parameters": {
+			modes:    "-k+cl",
+			expected: true,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, noColourMode(tc.modes, tc.current))
+		})
+	}
+}
+
+func TestChannelManagerStripFormatting(t *testing.T) {
+	chm := NewChannelManager(&ircevent.Connection{})
+	chm.SetStripFormatting([]string{"#Plain"})
+
+	assert.True(t, chm.StripFormatting("#plain"))
+	assert.False(t, chm.StripFormatting("#colour"))
+
+	chm.SetModes("#colour", "+c")
+	assert.True(t, chm.StripFormatting("#Colour"))
+
+	chm.SetModes("#colour", "-c")
+	assert.False(t, chm.StripFormatting("#colour"))
+
+	chm.SetStripFormatting(nil)
+	assert.False(t, chm.StripFormatting("#plain"))
+}
+
+func TestChannelManagerPartIgnoresCase(t *testing.T) {
+	chm := NewChannelManager(&ircevent.Connection{})
+
+	assert.Nil(t, chm.Join("#Foo", ""))
+	assert.Nil(t, chm.Join("#FOO", ""))
+	assert.Equal(t, []string{"#FOO"}, chm.Channels())
+
+	assert.Nil(t, chm.Part("#foo", ""))
+	assert.False(t, chm.Joined("#foo"))
+	assert.Empty(t, chm.Channels())
+}
+
+func TestChannelManagerPresence(t *testing.T) {
+	s := newTestIrcServer(t, "")
+	s.banned = []string{"#banned"}
+	n := newTestNetwork(t, s, &Config{Channels: []string{"#gowon", "#banned", "#other"}})
+	connectTestNetwork(t, n)
+
+	assert.Eventually(t, func() bool {
+		return n.chm.In("#gowon") && n.chm.In("#other")
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.False(t, n.chm.In("#banned"))
+	assert.True(t, n.chm.Joined("#banned"))
+
+	s.send(":op!op@test.host KICK #gowon gowon :bye")
+	assert.Eventually(t, func() bool {
+		return !n.chm.In("#gowon")
+	}, 5*time.Second, 10*time.Millisecond)
+
+	_, status, errBody := deliverMessage(Networks{n}, nil, []byte(`{"module": "test", "msg": "hello", "dest": "#gowon"}`))
+	assert.Equal(t, http.StatusForbidden, status)
+	assert.Equal(t, notJoinedErrMsg, errBody["error"])
+
+	assert.Nil(t, n.chm.Part("#other", ""))
+	assert.Eventually(t, func() bool {
+		return !n.chm.In("#other")
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestPersistChannel(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "invited.yaml")
+
+	assert.Nil(t, persistChannel(fn, defaultNetwork, "#a"))
+	assert.Nil(t, persistChannel(fn, defaultNetwork, "#b"))
+	assert.Nil(t, persistChannel(fn, defaultNetwork, "#A"))
+	assert.Nil(t, persistChannel(fn, "libera", "#c"))
+	assert.Nil(t, persistChannel(fn, "libera", "#d"))
+
+	cm := NewConfigManager()
+	assert.Nil(t, cm.OpenFile(fn))
+	assert.Equal(t, []string{"#a", "#b"}, cm.ConfigFiles[fn].Channels)
+	assert.Equal(t, []NetworkConfig{{Name: "libera", Channels: []string{"#c", "#d"}}}, cm.ConfigFiles[fn].Networks)
+}
+
+func TestForgetChannel(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "invited.yaml")
+
+	assert.Nil(t, forgetChannel(fn, defaultNetwork, "#a"))
+	assert.NoFileExists(t, fn)
+
+	assert.Nil(t, persistChannel(fn, defaultNetwork, "#a"))
+	assert.Nil(t, persistChannel(fn, defaultNetwork, "#b"))
+	assert.Nil(t, persistChannel(fn, "libera", "#c"))
+
+	assert.Nil(t, forgetChannel(fn, defaultNetwork, "#A"))
+	assert.Nil(t, forgetChannel(fn, "libera", "#c"))
+	assert.Nil(t, forgetChannel(fn, "oftc", "#d"))
+
+	cm := NewConfigManager()
+	assert.Nil(t, cm.OpenFile(fn))
+	assert.Equal(t, []string{"#b"}, cm.ConfigFiles[fn].Channels)
+	assert.Equal(t, []NetworkConfig{{Name: "libera", Channels: []string{}}}, cm.ConfigFiles[fn].Networks)
+}
+
+func TestNetworkPartForgetsInvite(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "invited.yaml")
+	assert.Nil(t, persistChannel(fn, defaultNetwork, "#invited"))
+
+	cm := NewConfigManager()
+	cm.MergedConfig = &Config{InvitesFile: fn}
+
+	irccon := &ircevent.Connection{Nick: "gowon", User: "gowon"}
+	n := &Network{Name: defaultNetwork, irccon: irccon, cm: cm, chm: NewChannelManager(irccon)}
+	assert.Nil(t, n.Join("#invited", ""))
+	assert.Nil(t, n.Part("#invited", ""))
+
+	cm = NewConfigManager()
+	assert.Nil(t, cm.OpenFile(fn))
+	assert.Empty(t, cm.ConfigFiles[fn].Channels)
+}
@@ -1,41 +1,215 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"net"
+	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"dario.cat/mergo"
 	"github.com/go-playground/validator/v10"
+	"github.com/gowon-irc/gowon/pkg/message"
 	"gopkg.in/yaml.v3"
 )
 
 const (
 	ircChannelRegex = `^[#&][^ ,\n\x07]+$`
+	ircNickRegex    = `^[a-zA-Z\[\]\\\x60_^{|}][a-zA-Z0-9\[\]\\\x60_^{|}-]*$`
+
+	invitesInConfigDirErrMsg = "invites_file must be outside the config directory"
 )
 
 type Command struct {
-	Command  string `validate:"alphanum"`
-	Endpoint string `validate:"url"`
+	Command  string `validate:"required_unless=Type grpc,omitempty,alphanum"`
+	Type     string `validate:"omitempty,oneof=http mqtt grpc"`
+	Endpoint string `validate:"required_unless=Type mqtt,omitempty,endpoint_url|grpc_target"`
+	Topic    string `validate:"required_if=Type mqtt,omitempty,mqtt_topic"`
 	Regex    string
 	Help     string
 	Priority int
 }
 
 type Config struct {
-	Server    string   `short:"s" long:"server" env:"GOWON_SERVER" description:"IRC server:port" validate:"required,hostname_port"`
-	User      string   `short:"u" long:"user" env:"GOWON_USER" description:"Bot user" validate:"required,alphanum"`
-	Nick      string   `short:"n" long:"nick" env:"GOWON_NICK" description:"Bot nick" validate:"required,alphanum"`
+	Server    string   `short:"s" long:"server" env:"GOWON_SERVER" description:"IRC server:port" validate:"omitempty,hostname_port"`
+	User      string   `short:"u" long:"user" env:"GOWON_USER" description:"Bot user" validate:"required_with=Server,omitempty,alphanum"`
+	Nick      string   `short:"n" long:"nick" env:"GOWON_NICK" description:"Bot nick" validate:"required_with=Server,omitempty,alphanum"`
 	Password  string   `short:"p" long:"password" env:"GOWON_PASSWORD" description:"Bot password"`
-	Channels  []string `short:"c" long:"channels" env:"GOWON_CHANNELS" env-delim:"," description:"Channels to join" validate:"required,dive,irc_channel"`
+	Channels  []string `short:"c" long:"channels" env:"GOWON_CHANNELS" env-delim:"," description:"Channels to join" validate:"required_with=Server,dive,irc_channel"`
 	UseTLS    bool     `short:"T" long:"tls" env:"GOWON_TLS" description:"Connect to irc server using tls"`
 	Verbose   bool     `short:"v" long:"verbose" env:"GOWON_VERBOSE" description:"Verbose logging"`
 	Debug     bool     `short:"d" long:"debug" env:"GOWON_DEBUG" description:"Debug logging"`
 	HttpPort  int      `short:"P" long:"http-port" env:"GOWON_HTTP_PORT" default:"8080" description:"http port" validate:"min=1,max=65535"`
 	ConfigDir string   `short:"C" long:"config-dir" env:"GOWON_CONFIG_DIR" default:"." description:"config directory"`
+	Admins    []string `short:"a" long:"admins" env:"GOWON_ADMINS" env-delim:"," description:"Admin hostmasks (nick!user@host, * and ? wildcards allowed)"`
+
+	ChannelKeys map[string]string `long:"channel-keys" env:"GOWON_CHANNEL_KEYS" env-delim:"," description:"Channel keys (channel:key)" yaml:"channel_keys" validate:"dive,keys,irc_channel,endkeys"`
+	OnInvite    string            `long:"on-invite" env:"GOWON_ON_INVITE" default:"ignore" description:"Invite policy (ignore, admins, everyone)" yaml:"on_invite" validate:"omitempty,oneof=ignore admins everyone"`
+	InvitesFile string            `long:"invites-file" env:"GOWON_INVITES_FILE" description:"File to save channels joined through invites to, outside the config directory" yaml:"invites_file"`
+
+	StripFormatting []string `long:"strip-formatting" env:"GOWON_STRIP_FORMATTING" env-delim:"," description:"Channels to send messages to without colours or formatting" yaml:"strip_formatting" validate:"dive,irc_channel"`
+
+	AltNicks       []string      `long:"alt-nicks" env:"GOWON_ALT_NICKS" env-delim:"," description:"Nicks to try if nick is taken" yaml:"alt_nicks" validate:"dive,irc_nick"`
+	RegainInterval time.Duration `long:"regain-interval" env:"GOWON_REGAIN_INTERVAL" default:"1m" description:"How often to try to regain nick" yaml:"regain_interval"`
+	NickServ       string        `long:"nickserv" env:"GOWON_NICKSERV" default:"none" description:"NickServ command used to regain nick (none, regain, ghost)" yaml:"nickserv" validate:"omitempty,oneof=none regain ghost"`
+
+	TLSCert     string `long:"tls-cert" env:"GOWON_TLS_CERT" description:"Client certificate for CertFP" yaml:"tls_cert" validate:"required_if=SASLMech EXTERNAL,omitempty,file"`
+	TLSKey      string `long:"tls-key" env:"GOWON_TLS_KEY" description:"Client certificate key for CertFP" yaml:"tls_key" validate:"required_with=TLSCert,omitempty,file"`
+	SASLMech    string `long:"sasl-mech" env:"GOWON_SASL_MECH" default:"PLAIN" description:"SASL mechanism (PLAIN, EXTERNAL)" yaml:"sasl_mech" validate:"omitempty,oneof=PLAIN EXTERNAL"`
+	SASLAccount string `long:"sasl-account" env:"GOWON_SASL_ACCOUNT" description:"SASL account name, defaults to nick" yaml:"sasl_account"`
+
+	ReconnectMin time.Duration `long:"reconnect-min" env:"GOWON_RECONNECT_MIN" default:"1s" description:"Minimum delay between reconnection attempts" yaml:"reconnect_min"`
+	ReconnectMax time.Duration `long:"reconnect-max" env:"GOWON_RECONNECT_MAX" default:"5m" description:"Maximum delay between reconnection attempts" yaml:"reconnect_max" validate:"gtefield=ReconnectMin"`
+	PingInterval time.Duration `long:"ping-interval" env:"GOWON_PING_INTERVAL" default:"4m" description:"How often to ping the server" yaml:"ping_interval" validate:"gtefield=PingTimeout"`
+	PingTimeout  time.Duration `long:"ping-timeout" env:"GOWON_PING_TIMEOUT" default:"1m" description:"How long to wait for a ping reply before reconnecting" yaml:"ping_timeout"`
+	LagInterval  time.Duration `long:"lag-interval" env:"GOWON_LAG_INTERVAL" default:"30s" description:"How often to measure lag, 0 to disable" yaml:"lag_interval"`
+	MaxLag       time.Duration `long:"max-lag" env:"GOWON_MAX_LAG" description:"Reconnect when lag exceeds this, 0 to disable" yaml:"max_lag"`
+
+	RelayRate  float64 `long:"relay-rate" env:"GOWON_RELAY_RATE" default:"1" description:"Relayed messages per second per network" yaml:"relay_rate" validate:"gt=0"`
+	RelayBurst int     `long:"relay-burst" env:"GOWON_RELAY_BURST" default:"5" description:"Relayed messages that can be sent at once before rate limiting" yaml:"relay_burst" validate:"min=1"`
 
-	Commands []Command `validate:"dive"`
+	MaxLines     int    `long:"max-lines" env:"GOWON_MAX_LINES" description:"Maximum lines sent in reply to a command, counting long lines once split, 0 for no limit" yaml:"max_lines" validate:"min=0"`
+	PasteBackend string `long:"paste-backend" env:"GOWON_PASTE_BACKEND" default:"none" description:"Where replies longer than max-lines are pasted (none, local)" yaml:"paste_backend" validate:"omitempty,oneof=none local"`
+	PasteDir     string `long:"paste-dir" env:"GOWON_PASTE_DIR" default:"pastes" description:"Directory the local paste backend stores pastes in" yaml:"paste_dir"`
+	PasteURL     string `long:"paste-url" env:"GOWON_PASTE_URL" description:"Public URL of the http server, used in links to local pastes" yaml:"paste_url" validate:"required_if=PasteBackend local,omitempty,url"`
+
+	MaxLineLength int `long:"max-line-length" env:"GOWON_MAX_LINE_LENGTH" default:"512" description:"Maximum length in bytes of a line sent by the server, including the bot's hostmask" yaml:"max_line_length" validate:"omitempty,min=128"`
+
+	HttpAddr   string     `long:"http-addr" env:"GOWON_HTTP_ADDR" default:"0.0.0.0" description:"Address the http server listens on" yaml:"http_addr" validate:"omitempty,ip"`
+	HttpSocket string     `long:"http-socket" env:"GOWON_HTTP_SOCKET" description:"Unix socket the http server listens on instead of an address and port" yaml:"http_socket"`
+	ApiTokens  []ApiToken `yaml:"api_tokens" validate:"unique=Token,dive"`
+	ApiNoAuth  bool       `long:"api-no-auth" env:"GOWON_API_NO_AUTH" description:"Leave the http api open to anyone when no api tokens are configured, instead of refusing every request" yaml:"api_no_auth"`
+
+	AllowedDestinations []string `long:"allowed-destinations" env:"GOWON_ALLOWED_DESTINATIONS" env-delim:"," description:"Channels and nicks the http api can message without the bot having joined them (* and ? wildcards allowed)" yaml:"allowed_destinations"`
+
+	Broker     string `short:"b" long:"broker" env:"GOWON_BROKER" description:"MQTT broker host:port used as a module transport" yaml:"broker" validate:"omitempty,hostname_port"`
+	MqttPrefix string `long:"mqtt-prefix" env:"GOWON_MQTT_PREFIX" default:"gowon" description:"Prefix of the MQTT topics events are published to and replies are read from. Any client of the broker can post to the output topic, so restrict it with the broker's ACLs" yaml:"mqtt_prefix" validate:"omitempty,mqtt_topic"`
+	MqttClient string `long:"mqtt-client-id" env:"GOWON_MQTT_CLIENT_ID" default:"gowon" description:"MQTT client id" yaml:"mqtt_client_id"`
+
+	ForgeSecret string       `long:"forge-secret" env:"GOWON_FORGE_SECRET" description:"Secret used to verify github and gitea webhook signatures" yaml:"forge_secret" validate:"required_with=ForgeRoutes"`
+	ForgeRoutes []ForgeRoute `yaml:"forge_routes" validate:"dive"`
+
+	Networks []NetworkConfig `validate:"required_without=Server,unique=Name,dive"`
+	Relays   []Relay         `validate:"dive"`
+	Hooks    []Hook          `validate:"unique=Name,dive"`
+	Commands []Command       `validate:"dive"`
+}
+
+type NetworkConfig struct {
+	Name        string `validate:"required,alphanum,ne=default"`
+	Server      string `validate:"required,hostname_port"`
+	User        string `validate:"required,alphanum"`
+	Nick        string `validate:"required,alphanum"`
+	Password    string
+	Channels    []string          `validate:"required,dive,irc_channel"`
+	ChannelKeys map[string]string `yaml:"channel_keys" validate:"dive,keys,irc_channel,endkeys"`
+	UseTLS      bool
+	TLSCert     string   `yaml:"tls_cert" validate:"required_if=SASLMech EXTERNAL,omitempty,file"`
+	TLSKey      string   `yaml:"tls_key" validate:"required_with=TLSCert,omitempty,file"`
+	SASLMech    string   `yaml:"sasl_mech" validate:"omitempty,oneof=PLAIN EXTERNAL"`
+	SASLAccount string   `yaml:"sasl_account"`
+	AltNicks    []string `yaml:"alt_nicks" validate:"dive,irc_nick"`
+	NickServ    string   `yaml:"nickserv" validate:"omitempty,oneof=none regain ghost"`
+
+	StripFormatting []string `yaml:"strip_formatting" validate:"dive,irc_channel"`
+}
+
+func (cfg *Config) defaultNetwork() NetworkConfig {
+	return NetworkConfig{
+		Name:        defaultNetwork,
+		Server:      cfg.Server,
+		User:        cfg.User,
+		Nick:        cfg.Nick,
+		Password:    cfg.Password,
+		Channels:    cfg.Channels,
+		ChannelKeys: cfg.ChannelKeys,
+		UseTLS:      cfg.UseTLS,
+		TLSCert:     cfg.TLSCert,
+		TLSKey:      cfg.TLSKey,
+		SASLMech:    cfg.SASLMech,
+		SASLAccount: cfg.SASLAccount,
+		AltNicks:    cfg.AltNicks,
+		NickServ:    cfg.NickServ,
+
+		StripFormatting: cfg.StripFormatting,
+	}
+}
+
+func (cfg *Config) AllNetworks() []NetworkConfig {
+	out := []NetworkConfig{}
+
+	if cfg.Server != "" {
+		out = append(out, cfg.defaultNetwork())
+	}
+
+	return append(out, cfg.Networks...)
+}
+
+// checkNetworks makes sure everything that names a network names one that
+// is configured, where an empty name means the first network.
+func checkNetworks(cfg *Config) error {
+	known := func(name string) bool {
+		_, ok := cfg.GetNetwork(name)
+		return name == "" || ok
+	}
+
+	for _, h := range cfg.Hooks {
+		if !known(h.Network) {
+			return fmt.Errorf("hook %s: %s: %s", h.Name, unknownNetworkErrMsg, h.Network)
+		}
+	}
+
+	for _, r := range cfg.Relays {
+		for _, name := range []string{r.FromNetwork, r.ToNetwork} {
+			if !known(name) {
+				return fmt.Errorf("relay from %s to %s: %s: %s", r.From, r.To, unknownNetworkErrMsg, name)
+			}
+		}
+	}
+
+	for _, f := range cfg.ForgeRoutes {
+		if !known(f.Network) {
+			return fmt.Errorf("forge route for %s: %s: %s", f.Repository, unknownNetworkErrMsg, f.Network)
+		}
+	}
+
+	return nil
+}
+
+func (cfg *Config) GetNetwork(name string) (NetworkConfig, bool) {
+	for _, n := range cfg.AllNetworks() {
+		if n.Name == name {
+			return n, true
+		}
+	}
+
+	return NetworkConfig{}, false
+}
+
+func mergeNetworks(networks []NetworkConfig) ([]NetworkConfig, error) {
+	var out []NetworkConfig
+	index := map[string]int{}
+
+	for _, n := range networks {
+		i, ok := index[n.Name]
+		if !ok {
+			index[n.Name] = len(out)
+			out = append(out, n)
+			continue
+		}
+
+		if err := mergo.Merge(&out[i], n, mergo.WithOverride, mergo.WithAppendSlice); err != nil {
+			return nil, err
+		}
+	}
+
+	return out, nil
 }
 
 func validateIrcChannel(field validator.FieldLevel) bool {
@@ -43,10 +217,50 @@ func validateIrcChannel(field validator.FieldLevel) bool {
 	return re.MatchString(field.Field().String())
 }
 
+func validateIrcNick(field validator.FieldLevel) bool {
+	re := regexp.MustCompile(ircNickRegex)
+	return re.MatchString(field.Field().String())
+}
+
+func validateEndpointURL(field validator.FieldLevel) bool {
+	if _, ok := unixSocketPath(field.Field().String()); ok {
+		return true
+	}
+
+	u, err := url.Parse(field.Field().String())
+	return err == nil && u.Scheme != "" && u.Scheme != unixScheme && u.Host != ""
+}
+
+// grpc modules can also be given as host:port, which isn't a url.
+func validateGrpcTarget(field validator.FieldLevel) bool {
+	if field.Parent().FieldByName("Type").String() != commandTypeGrpc {
+		return false
+	}
+
+	host, port, err := net.SplitHostPort(field.Field().String())
+	if err != nil || host == "" {
+		return false
+	}
+
+	p, err := strconv.Atoi(port)
+	return err == nil && p > 0 && p <= 65535
+}
+
+func validateMqttTopic(field validator.FieldLevel) bool {
+	t := field.Field().String()
+	return t != "" && !strings.ContainsAny(t, "#+\x00")
+}
+
+func validateMessageFilter(field validator.FieldLevel) bool {
+	return message.CheckFilter(field.Field().String()) == nil
+}
+
 type ConfigManager struct {
 	Opts         Config
 	ConfigFiles  map[string]Config
 	MergedConfig *Config
+
+	mu sync.RWMutex
 }
 
 func NewConfigManager() *ConfigManager {
@@ -98,18 +312,80 @@ func (cm *ConfigManager) AddOpts(config Config) {
 	cm.Opts = config
 }
 
-func (cm *ConfigManager) Merge() error {
-	cm.MergedConfig = &Config{}
+// Config returns the merged config, which is replaced rather than changed
+// on reload, so it can be read while one is happening.
+func (cm *ConfigManager) Config() *Config {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	return cm.MergedConfig
+}
+
+func (cm *ConfigManager) SetConfig(cfg *Config) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
 
-	if err := mergo.Merge(cm.MergedConfig, cm.Opts, mergo.WithOverride); err != nil {
+	cm.MergedConfig = cfg
+}
+
+// LoadInvites adds the channels saved from invites. The file is kept out of
+// the config directory, where writing it would trigger a reload.
+func (cm *ConfigManager) LoadInvites(filename, configDir string) error {
+	if filename == "" {
+		return nil
+	}
+
+	dir, err := filepath.Abs(filepath.Dir(filename))
+	if err != nil {
 		return err
 	}
 
+	cd, err := filepath.Abs(configDir)
+	if err != nil {
+		return err
+	}
+
+	if dir == cd {
+		return errors.New(invitesInConfigDirErrMsg)
+	}
+
+	if err := cm.OpenFile(filename); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+func (cm *ConfigManager) merge() (*Config, error) {
+	merged := &Config{}
+
+	if err := mergo.Merge(merged, cm.Opts, mergo.WithOverride); err != nil {
+		return nil, err
+	}
+
 	for _, cfg := range cm.ConfigFiles {
-		if err := mergo.Merge(cm.MergedConfig, cfg, mergo.WithOverride, mergo.WithAppendSlice); err != nil {
-			return err
+		if err := mergo.Merge(merged, cfg, mergo.WithOverride, mergo.WithAppendSlice); err != nil {
+			return nil, err
 		}
 	}
 
+	networks, err := mergeNetworks(merged.Networks)
+	if err != nil {
+		return nil, err
+	}
+
+	merged.Networks = networks
+
+	return merged, nil
+}
+
+func (cm *ConfigManager) Merge() error {
+	merged, err := cm.merge()
+	if err != nil {
+		return err
+	}
+
+	cm.SetConfig(merged)
+
 	return nil
 }
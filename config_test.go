@@ -1,10 +1,13 @@
 package main
 
 import (
+	"os"
 	"path/filepath"
 	"regexp"
 	"testing"
 
+	"github.com/go-playground/validator/v10"
+	"github.com/jessevdk/go-flags"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -53,6 +56,39 @@ func TestConfigIrcChannelRegex(t *testing.T) {
 	}
 }
 
+func TestConfigIrcNickRegex(t *testing.T) {
+	cases := map[string]struct {
+		input string
+		match bool
+	}{
+		"nick": {
+			input: "gowon",
+			match: true,
+		},
+		"nick with special characters": {
+			input: "gowon_[away]|2",
+			match: true,
+		},
+		"starts with number": {
+			input: "2gowon",
+			match: false,
+		},
+		"contains space": {
+			input: "go won",
+			match: false,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			matched, err := regexp.MatchString(ircNickRegex, tc.input)
+
+			assert.Equal(t, matched, tc.match)
+			assert.Nil(t, err)
+		})
+	}
+}
+
 func TestNewConfigManager(t *testing.T) {
 	cm := NewConfigManager()
 
@@ -187,3 +223,288 @@ func TestConfigManagerMerge(t *testing.T) {
 		})
 	}
 }
+
+func TestMergeNetworks(t *testing.T) {
+	cases := map[string]struct {
+		networks []NetworkConfig
+		expected []NetworkConfig
+	}{
+		"no networks": {
+			networks: []NetworkConfig{},
+			expected: nil,
+		},
+		"different networks": {
+			networks: []NetworkConfig{{Name: "a"}, {Name: "b"}},
+			expected: []NetworkConfig{{Name: "a"}, {Name: "b"}},
+		},
+		"same network": {
+			networks: []NetworkConfig{
+				{Name: "a", Server: "irc.a.net:6697", Channels: []string{"#a"}},
+				{Name: "b", Server: "irc.b.net:6697"},
+				{Name: "a", Nick: "gowon", Channels: []string{"#b"}},
+			},
+			expected: []NetworkConfig{
+				{Name: "a", Server: "irc.a.net:6697", Nick: "gowon", Channels: []string{"#a", "#b"}},
+				{Name: "b", Server: "irc.b.net:6697"},
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			out, err := mergeNetworks(tc.networks)
+
+			assert.Equal(t, tc.expected, out)
+			assert.Nil(t, err)
+		})
+	}
+}
+
+func TestConfigAllNetworks(t *testing.T) {
+	cases := map[string]struct {
+		cfg      Config
+		expected []string
+	}{
+		"default network only": {
+			cfg:      Config{Server: "irc.a.net:6697"},
+			expected: []string{defaultNetwork},
+		},
+		"networks only": {
+			cfg:      Config{Networks: []NetworkConfig{{Name: "a"}, {Name: "b"}}},
+			expected: []string{"a", "b"},
+		},
+		"default and networks": {
+			cfg:      Config{Server: "irc.a.net:6697", Networks: []NetworkConfig{{Name: "b"}}},
+			expected: []string{defaultNetwork, "b"},
+		},
+		"no networks": {
+			cfg:      Config{},
+			expected: []string{},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			out := []string{}
+			for _, n := range tc.cfg.AllNetworks() {
+				out = append(out, n.Name)
+			}
+
+			assert.Equal(t, tc.expected, out)
+		})
+	}
+}
+
+func TestConfigGetNetwork(t *testing.T) {
+	cfg := Config{
+		Server:   "irc.a.net:6697",
+		Nick:     "gowon",
+		Networks: []NetworkConfig{{Name: "b", Nick: "gowonb"}},
+	}
+
+	n, ok := cfg.GetNetwork(defaultNetwork)
+	assert.True(t, ok)
+	assert.Equal(t, "gowon", n.Nick)
+
+	n, ok = cfg.GetNetwork("b")
+	assert.True(t, ok)
+	assert.Equal(t, "gowonb", n.Nick)
+
+	_, ok = cfg.GetNetwork("c")
+	assert.False(t, ok)
+}
+
+func TestCommandValidation(t *testing.T) {
+	v := validator.New(validator.WithRequiredStructEnabled())
+	assert.Nil(t, v.RegisterValidation("mqtt_topic", validateMqttTopic))
+	assert.Nil(t, v.RegisterValidation("endpoint_url", validateEndpointURL))
+	assert.Nil(t, v.RegisterValidation("grpc_target", validateGrpcTarget))
+
+	cases := map[string]struct {
+		cmd   Command
+		valid bool
+	}{
+		"http": {
+			cmd:   Command{Command: "echo", Endpoint: "http://echo:8080"},
+			valid: true,
+		},
+		"unix socket": {
+			cmd:   Command{Command: "echo", Endpoint: "unix:///run/gowon/echo.sock"},
+			valid: true,
+		},
+		"relative unix socket": {
+			cmd: Command{Command: "echo", Endpoint: "unix://echo.sock"},
+		},
+		"http host and port": {
+			cmd: Command{Command: "echo", Endpoint: "echo:8080"},
+		},
+		"http without endpoint": {
+			cmd: Command{Command: "echo"},
+		},
+		"mqtt": {
+			cmd:   Command{Command: "echo", Type: commandTypeMqtt, Topic: "modules/echo"},
+			valid: true,
+		},
+		"mqtt without topic": {
+			cmd: Command{Command: "echo", Type: commandTypeMqtt},
+		},
+		"mqtt wildcard topic": {
+			cmd: Command{Command: "echo", Type: commandTypeMqtt, Topic: "modules/#"},
+		},
+		"grpc": {
+			cmd:   Command{Command: "echo", Type: commandTypeGrpc, Endpoint: "127.0.0.1:9000"},
+			valid: true,
+		},
+		"grpc from manifest": {
+			cmd:   Command{Type: commandTypeGrpc, Endpoint: "echo:9000"},
+			valid: true,
+		},
+		"grpc without port": {
+			cmd: Command{Command: "echo", Type: commandTypeGrpc, Endpoint: "echo"},
+		},
+		"grpc without endpoint": {
+			cmd: Command{Type: commandTypeGrpc},
+		},
+		"http without command": {
+			cmd: Command{Endpoint: "http://echo:8080"},
+		},
+		"unknown type": {
+			cmd: Command{Command: "echo", Type: "smtp", Endpoint: "http://echo:8080"},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			err := v.Struct(tc.cmd)
+			assert.Equal(t, tc.valid, err == nil, err)
+		})
+	}
+}
+
+func TestConfigManagerLoadInvites(t *testing.T) {
+	configDir := t.TempDir()
+	invitesDir := t.TempDir()
+
+	invites := filepath.Join(invitesDir, "invited.yaml")
+	assert.Nil(t, persistChannel(invites, defaultNetwork, "#invited"))
+
+	cases := map[string]struct {
+		fn     string
+		errMsg string
+		length int
+	}{
+		"disabled": {
+			fn:     "",
+			length: 0,
+		},
+		"outside config directory": {
+			fn:     invites,
+			length: 1,
+		},
+		"not written yet": {
+			fn:     filepath.Join(invitesDir, "nonexistent.yaml"),
+			length: 0,
+		},
+		"inside config directory": {
+			fn:     filepath.Join(configDir, "invited.yaml"),
+			errMsg: invitesInConfigDirErrMsg,
+			length: 0,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			cm := NewConfigManager()
+			err := cm.LoadInvites(tc.fn, configDir)
+
+			assert.Len(t, cm.ConfigFiles, tc.length)
+
+			if tc.errMsg == "" {
+				assert.Nil(t, err)
+			} else {
+				assert.EqualError(t, err, tc.errMsg)
+			}
+		})
+	}
+}
+
+func TestLoadConfigRejectsWithoutPublishing(t *testing.T) {
+	dir := t.TempDir()
+	required := "server: irc.kwlchat.net:6997\nuser: gowon\nnick: gowon\nchannels:\n  - \"#owls\"\n"
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "gowon.yaml"), []byte(required), 0o644))
+
+	// the flag defaults fill in what the file leaves out
+	opts := Config{}
+	_, err := flags.ParseArgs(&opts, []string{})
+	assert.Nil(t, err)
+
+	cm := NewConfigManager()
+	cm.AddOpts(opts)
+	assert.Nil(t, loadConfig(cm, dir))
+
+	good := cm.Config()
+	assert.Equal(t, "gowon", good.Nick)
+
+	bad := "api_tokens:\n  - name: short\n    token: abc\n"
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "tokens.yaml"), []byte(bad), 0o644))
+
+	assert.Error(t, loadConfig(cm, dir))
+	assert.Same(t, good, cm.Config())
+}
+
+func TestCheckNetworks(t *testing.T) {
+	cases := map[string]struct {
+		hooks  []Hook
+		relays []Relay
+		forge  []ForgeRoute
+		err    bool
+	}{
+		"first network": {
+			hooks:  []Hook{{Name: "ci"}},
+			relays: []Relay{{From: "#a", To: "#b"}},
+		},
+		"configured networks": {
+			hooks:  []Hook{{Name: "ci", Network: defaultNetwork}},
+			relays: []Relay{{FromNetwork: defaultNetwork, From: "#a", ToNetwork: "oftc", To: "#b"}},
+		},
+		"unknown hook network": {
+			hooks: []Hook{{Name: "ci", Network: "efnet"}},
+			err:   true,
+		},
+		"unknown relay from network": {
+			relays: []Relay{{FromNetwork: "efnet", From: "#a", To: "#b"}},
+			err:    true,
+		},
+		"unknown relay to network": {
+			relays: []Relay{{From: "#a", ToNetwork: "oftcc", To: "#b"}},
+			err:    true,
+		},
+		"forge route network": {
+			forge: []ForgeRoute{{Repository: "gowon-irc/gowon", Network: "oftc", Dest: "#gowon"}},
+		},
+		"unknown forge route network": {
+			forge: []ForgeRoute{{Repository: "gowon-irc/gowon", Network: "efnet", Dest: "#gowon"}},
+			err:   true,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			cfg := &Config{
+				Server:      "irc.libera.chat:6697",
+				Networks:    []NetworkConfig{{Name: "oftc"}},
+				Hooks:       tc.hooks,
+				Relays:      tc.relays,
+				ForgeRoutes: tc.forge,
+			}
+
+			err := checkNetworks(cfg)
+			if tc.err {
+				assert.ErrorContains(t, err, unknownNetworkErrMsg)
+				return
+			}
+
+			assert.Nil(t, err)
+		})
+	}
+}
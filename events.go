@@ -0,0 +1,174 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/ergochat/irc-go/ircmsg"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/gowon-irc/gowon/pkg/message"
+)
+
+const eventBufferSize = 64
+
+var streamedEvents = []string{
+	"PRIVMSG", "NOTICE", "JOIN", "PART", "QUIT", "KICK", "NICK", "MODE", "TOPIC", "INVITE",
+	"AWAY", "ACCOUNT", "CHGHOST", "TAGMSG",
+}
+
+var upgrader = websocket.Upgrader{}
+
+type subscriber struct {
+	filter string
+	token  *ApiToken
+	events chan *message.Message
+}
+
+type EventHub struct {
+	mu          sync.Mutex
+	subscribers map[*subscriber]bool
+}
+
+func NewEventHub() *EventHub {
+	return &EventHub{
+		subscribers: make(map[*subscriber]bool),
+	}
+}
+
+func (h *EventHub) Subscribe(filter string, token *ApiToken) (*subscriber, error) {
+	if filter != "" {
+		if err := message.CheckFilter(filter); err != nil {
+			return nil, err
+		}
+	}
+
+	s := &subscriber{
+		filter: filter,
+		token:  token,
+		events: make(chan *message.Message, eventBufferSize),
+	}
+
+	h.mu.Lock()
+	h.subscribers[s] = true
+	h.mu.Unlock()
+
+	return s, nil
+}
+
+func (h *EventHub) Unsubscribe(s *subscriber) {
+	h.mu.Lock()
+	delete(h.subscribers, s)
+	h.mu.Unlock()
+}
+
+func (s *subscriber) wants(m *message.Message) bool {
+	if s.token != nil && !s.token.AllowedEvent(m.Network, m.Dest) {
+		return false
+	}
+
+	if s.filter == "" {
+		return true
+	}
+
+	ok, _ := message.Filter(m, s.filter)
+	return ok
+}
+
+func (h *EventHub) Publish(m *message.Message) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for s := range h.subscribers {
+		if !s.wants(m) {
+			continue
+		}
+
+		select {
+		case s.events <- m:
+		default:
+			log.Printf("Event stream subscriber is not keeping up, dropping %s event", m.Code)
+		}
+	}
+}
+
+func (h *EventHub) Watch(n *Network) {
+	for _, e := range streamedEvents {
+		n.irccon.AddCallback(e, func(event ircmsg.Message) {
+			h.Publish(createMessage(n, event))
+		})
+	}
+}
+
+func streamSSE(c *gin.Context, s *subscriber) {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Writer.WriteHeaderNow()
+	c.Writer.Flush()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case m := <-s.events:
+			c.SSEvent(m.Code, m)
+			return true
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
+
+func streamWebsocket(c *gin.Context, s *subscriber) {
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	defer conn.Close()
+
+	closed := make(chan struct{})
+
+	go func() {
+		defer close(closed)
+
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case m := <-s.events:
+			if err := conn.WriteJSON(m); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
+
+func createEventsHandler(h *EventHub) func(*gin.Context) {
+	return func(c *gin.Context) {
+		if !authorisedKind(c, kindEvents) {
+			return
+		}
+
+		s, err := h.Subscribe(c.Query("filter"), contextToken(c))
+		if err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"filter": c.Query("filter"), "error": err.Error()})
+			return
+		}
+		defer h.Unsubscribe(s)
+
+		if websocket.IsWebSocketUpgrade(c.Request) {
+			streamWebsocket(c, s)
+			return
+		}
+
+		streamSSE(c, s)
+	}
+}
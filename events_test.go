@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/gowon-irc/gowon/pkg/message"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubscriberWants(t *testing.T) {
+	token := &ApiToken{Destinations: []string{"#gowon"}, Kinds: []string{kindEvents}}
+	unscoped := &ApiToken{Networks: []string{"libera"}, Destinations: []string{"*"}, Kinds: []string{kindEvents}}
+
+	cases := map[string]struct {
+		filter   string
+		token    *ApiToken
+		m        message.Message
+		expected bool
+	}{
+		"no filter": {
+			m:        message.Message{Code: "PRIVMSG", Dest: "#other"},
+			expected: true,
+		},
+		"matching filter": {
+			filter:   "code=JOIN+dest=#gowon",
+			m:        message.Message{Code: "JOIN", Dest: "#gowon"},
+			expected: true,
+		},
+		"non matching filter": {
+			filter:   "code=JOIN",
+			m:        message.Message{Code: "PRIVMSG", Dest: "#gowon"},
+			expected: false,
+		},
+		"token destination": {
+			token:    token,
+			m:        message.Message{Code: "PRIVMSG", Dest: "#gowon"},
+			expected: true,
+		},
+		"token other destination": {
+			token:    token,
+			m:        message.Message{Code: "PRIVMSG", Dest: "#other"},
+			expected: false,
+		},
+		"scoped token without destination": {
+			token:    token,
+			m:        message.Message{Code: "QUIT", Msg: "leaving"},
+			expected: false,
+		},
+		"scoped token nick change": {
+			token:    token,
+			m:        message.Message{Code: "NICK", Arguments: []string{"newnick"}},
+			expected: false,
+		},
+		"unscoped token without destination": {
+			token:    unscoped,
+			m:        message.Message{Code: "QUIT", Msg: "leaving", Network: "libera"},
+			expected: true,
+		},
+		"unscoped token other network": {
+			token:    unscoped,
+			m:        message.Message{Code: "QUIT", Msg: "leaving", Network: "oftc"},
+			expected: false,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			h := NewEventHub()
+			s, err := h.Subscribe(tc.filter, tc.token)
+			assert.Nil(t, err)
+
+			assert.Equal(t, tc.expected, s.wants(&tc.m))
+		})
+	}
+}
+
+func TestEventHubSubscribeInvalidFilter(t *testing.T) {
+	_, err := NewEventHub().Subscribe("dest!=d", nil)
+	assert.Error(t, err)
+}
+
+func TestEventHubPublish(t *testing.T) {
+	h := NewEventHub()
+
+	s, err := h.Subscribe("code=JOIN", nil)
+	assert.Nil(t, err)
+
+	h.Publish(&message.Message{Code: "PRIVMSG"})
+	h.Publish(&message.Message{Code: "JOIN", Dest: "#gowon"})
+
+	m := <-s.events
+	assert.Equal(t, "JOIN", m.Code)
+	assert.Len(t, s.events, 0)
+
+	h.Unsubscribe(s)
+	h.Publish(&message.Message{Code: "JOIN"})
+	assert.Len(t, s.events, 0)
+
+	for i := 0; i < eventBufferSize+1; i++ {
+		h.Publish(&message.Message{Code: "JOIN"})
+	}
+}
+
+func waitForSubscriber(t *testing.T, h *EventHub) {
+	assert.Eventually(t, func() bool {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		return len(h.subscribers) == 1
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestEventsSSE(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	h := NewEventHub()
+	r := gin.New()
+	r.GET("/events", createEventsHandler(h))
+
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/events?filter=code=JOIN", nil)
+	resp, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+
+	waitForSubscriber(t, h)
+	h.Publish(&message.Message{Code: "JOIN", Dest: "#gowon", Nick: "nick"})
+
+	lines := []string{}
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() && scanner.Text() != "" {
+		lines = append(lines, scanner.Text())
+	}
+
+	assert.Equal(t, "event:JOIN", lines[0])
+	assert.True(t, strings.HasPrefix(lines[1], "data:"))
+	assert.Contains(t, lines[1], `"dest":"#gowon"`)
+}
+
+func TestEventsSSEInvalidFilter(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	r.GET("/events", createEventsHandler(NewEventHub()))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/events?filter=nope", nil))
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestEventsWebsocket(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	h := NewEventHub()
+	r := gin.New()
+	r.GET("/events", createEventsHandler(h))
+
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/events", nil)
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	waitForSubscriber(t, h)
+	h.Publish(&message.Message{Code: "PRIVMSG", Dest: "#gowon", Msg: "hello"})
+
+	var m message.Message
+	assert.Nil(t, conn.ReadJSON(&m))
+	assert.Equal(t, "hello", m.Msg)
+	assert.Equal(t, "#gowon", m.Dest)
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gowon-irc/gowon/pkg/message"
+)
+
+const (
+	forgeModule = "forge"
+
+	githubEventHeader     = "X-GitHub-Event"
+	giteaEventHeader      = "X-Gitea-Event"
+	hubSignatureHeader    = "X-Hub-Signature-256"
+	giteaSignatureHeader  = "X-Gitea-Signature"
+	hubSignaturePrefix    = "sha256="
+	forgeBranchRefPrefix  = "refs/heads/"
+	forgeTagRefPrefix     = "refs/tags/"
+	forgeEventPing        = "ping"
+	forgeEventPush        = "push"
+	forgeEventPullRequest = "pull_request"
+	forgeEventIssues      = "issues"
+	forgeEventRelease     = "release"
+
+	invalidSignatureErrMsg = "missing or invalid webhook signature"
+	missingEventErrMsg     = "missing event header"
+)
+
+type ForgeRoute struct {
+	Repository string `validate:"required"`
+	Network    string
+	Dest       string   `validate:"required,irc_channel|irc_nick"`
+	Events     []string `validate:"dive,oneof=push pull_request issues release"`
+	Branches   []string
+}
+
+type forgeUser struct {
+	Login    string `json:"login"`
+	Username string `json:"username"`
+	Name     string `json:"name"`
+}
+
+func (u forgeUser) name() string {
+	for _, n := range []string{u.Login, u.Username, u.Name} {
+		if n != "" {
+			return n
+		}
+	}
+
+	return "someone"
+}
+
+type forgeCommit struct {
+	Message string `json:"message"`
+}
+
+type forgeIssue struct {
+	Number  int    `json:"number"`
+	Title   string `json:"title"`
+	HtmlURL string `json:"html_url"`
+	Merged  bool   `json:"merged"`
+	Base    struct {
+		Ref string `json:"ref"`
+	} `json:"base"`
+}
+
+type forgeEvent struct {
+	Action     string        `json:"action"`
+	Ref        string        `json:"ref"`
+	Created    bool          `json:"created"`
+	Deleted    bool          `json:"deleted"`
+	Compare    string        `json:"compare"`
+	CompareURL string        `json:"compare_url"`
+	Commits    []forgeCommit `json:"commits"`
+	HeadCommit *forgeCommit  `json:"head_commit"`
+	Pusher     forgeUser     `json:"pusher"`
+	Sender     forgeUser     `json:"sender"`
+	Repository struct {
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+	PullRequest *forgeIssue `json:"pull_request"`
+	Issue       *forgeIssue `json:"issue"`
+	Release     *struct {
+		TagName string `json:"tag_name"`
+		Name    string `json:"name"`
+		HtmlURL string `json:"html_url"`
+	} `json:"release"`
+}
+
+func validSignature(secret string, payload []byte, signature string) bool {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+
+	expected, err := hex.DecodeString(strings.TrimPrefix(signature, hubSignaturePrefix))
+	if err != nil {
+		return false
+	}
+
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// forgeSignature prefers the github style header, which gitea also sends,
+// falling back to gitea's own unprefixed one.
+func forgeSignature(c *gin.Context) string {
+	if s := c.GetHeader(hubSignatureHeader); s != "" {
+		if !strings.HasPrefix(s, hubSignaturePrefix) {
+			return ""
+		}
+		return s
+	}
+
+	return c.GetHeader(giteaSignatureHeader)
+}
+
+func forgeEventType(c *gin.Context) string {
+	if e := c.GetHeader(githubEventHeader); e != "" {
+		return e
+	}
+
+	return c.GetHeader(giteaEventHeader)
+}
+
+// firstLine returns the first line of text with formatting tokens escaped.
+func firstLine(text string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(text), "\n")
+	return message.EscapeTokens(strings.TrimSpace(line))
+}
+
+func (e *forgeEvent) branch() string {
+	if e.PullRequest != nil {
+		return e.PullRequest.Base.Ref
+	}
+
+	if b, ok := strings.CutPrefix(e.Ref, forgeBranchRefPrefix); ok {
+		return b
+	}
+
+	return ""
+}
+
+func plural(n int, word string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, word)
+	}
+
+	return fmt.Sprintf("%d %ss", n, word)
+}
+
+func formatPush(e *forgeEvent) (string, bool) {
+	who := message.EscapeTokens(e.Pusher.name())
+
+	if tag, ok := strings.CutPrefix(e.Ref, forgeTagRefPrefix); ok {
+		if e.Deleted {
+			return fmt.Sprintf("{bold}%s{bold} deleted tag {yellow}%s{clear}", who, message.EscapeTokens(tag)), true
+		}
+
+		return fmt.Sprintf("{bold}%s{bold} pushed tag {yellow}%s{clear}", who, message.EscapeTokens(tag)), true
+	}
+
+	branch := message.EscapeTokens(e.branch())
+
+	if e.Deleted {
+		return fmt.Sprintf("{bold}%s{bold} deleted branch {green}%s{clear}", who, branch), true
+	}
+
+	if len(e.Commits) == 0 {
+		if e.Created {
+			return fmt.Sprintf("{bold}%s{bold} created branch {green}%s{clear}", who, branch), true
+		}
+
+		return "", false
+	}
+
+	msg := fmt.Sprintf("{bold}%s{bold} pushed %s to {green}%s{clear}", who, plural(len(e.Commits), "commit"), branch)
+
+	head := e.HeadCommit
+	if head == nil {
+		head = &e.Commits[len(e.Commits)-1]
+	}
+	msg += ": " + firstLine(head.Message)
+
+	compare := e.Compare
+	if compare == "" {
+		compare = e.CompareURL
+	}
+	if compare != "" {
+		msg += " " + message.EscapeTokens(compare)
+	}
+
+	return msg, true
+}
+
+func actionColour(action string) string {
+	switch action {
+	case "opened", "reopened", "published":
+		return "green"
+	case "merged":
+		return "magenta"
+	default:
+		return "red"
+	}
+}
+
+func formatIssue(kind, action string, sender forgeUser, i *forgeIssue) (string, bool) {
+	if i == nil {
+		return "", false
+	}
+
+	switch action {
+	case "opened", "reopened":
+	case "closed":
+		if i.Merged {
+			action = "merged"
+		}
+	default:
+		return "", false
+	}
+
+	return fmt.Sprintf("{bold}%s{bold} {%s}%s{clear} %s #%d: %s %s",
+		message.EscapeTokens(sender.name()), actionColour(action), action, kind, i.Number,
+		firstLine(i.Title), message.EscapeTokens(i.HtmlURL)), true
+}
+
+func formatRelease(e *forgeEvent) (string, bool) {
+	if e.Release == nil || e.Action != "published" {
+		return "", false
+	}
+
+	name := e.Release.Name
+	if name == "" {
+		name = e.Release.TagName
+	}
+
+	return fmt.Sprintf("{bold}%s{bold} {green}published{clear} release {yellow}%s{clear} %s",
+		message.EscapeTokens(e.Sender.name()), firstLine(name), message.EscapeTokens(e.Release.HtmlURL)), true
+}
+
+// formatForgeEvent summarises an event on one line, returning false for
+// events and actions that aren't worth announcing.
+func formatForgeEvent(event string, e *forgeEvent) (string, bool) {
+	var msg string
+	var ok bool
+
+	switch event {
+	case forgeEventPush:
+		msg, ok = formatPush(e)
+	case forgeEventPullRequest:
+		msg, ok = formatIssue("pull request", e.Action, e.Sender, e.PullRequest)
+	case forgeEventIssues:
+		msg, ok = formatIssue("issue", e.Action, e.Sender, e.Issue)
+	case forgeEventRelease:
+		msg, ok = formatRelease(e)
+	}
+
+	if !ok {
+		return "", false
+	}
+
+	return fmt.Sprintf("[{cyan}%s{clear}] %s", message.EscapeTokens(e.Repository.FullName), msg), true
+}
+
+func (r *ForgeRoute) Match(event string, e *forgeEvent) bool {
+	if !matchMask(e.Repository.FullName, []string{r.Repository}) {
+		return false
+	}
+
+	if len(r.Events) > 0 {
+		found := false
+		for _, ev := range r.Events {
+			if ev == event {
+				found = true
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	if len(r.Branches) > 0 && (event == forgeEventPush || event == forgeEventPullRequest) {
+		return matchMask(e.branch(), r.Branches)
+	}
+
+	return true
+}
+
+func createForgeHandler(cm *ConfigManager, networks Networks) func(*gin.Context) {
+	return func(c *gin.Context) {
+		cfg := cm.Config()
+
+		payload, err := c.GetRawData()
+		if err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// without a secret anyone could sign a payload, so refuse them all
+		if cfg.ForgeSecret == "" || !validSignature(cfg.ForgeSecret, payload, forgeSignature(c)) {
+			c.IndentedJSON(http.StatusUnauthorized, gin.H{"error": invalidSignatureErrMsg})
+			return
+		}
+
+		event := forgeEventType(c)
+		if event == "" {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": missingEventErrMsg})
+			return
+		}
+
+		if event == forgeEventPing {
+			c.IndentedJSON(http.StatusOK, gin.H{"event": event})
+			return
+		}
+
+		var e forgeEvent
+		if err := json.Unmarshal(payload, &e); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"event": event, "error": err.Error()})
+			return
+		}
+
+		msg, ok := formatForgeEvent(event, &e)
+		if !ok {
+			c.IndentedJSON(http.StatusOK, gin.H{"event": event, "delivered": []gin.H{}})
+			return
+		}
+
+		delivered := []gin.H{}
+
+		for _, r := range cfg.ForgeRoutes {
+			if !r.Match(event, &e) {
+				continue
+			}
+
+			body, err := json.Marshal(message.Message{Module: forgeModule, Msg: msg, Dest: r.Dest, Network: r.Network})
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+
+			if _, status, errBody := deliverMessage(networks, nil, body); errBody != nil {
+				log.Printf("Could not deliver %s event for %s to %s: %s", event, e.Repository.FullName, r.Dest, errBody["error"])
+				delivered = append(delivered, gin.H{"network": r.Network, "dest": r.Dest, "status": status, "error": errBody["error"]})
+				continue
+			}
+
+			delivered = append(delivered, gin.H{"network": r.Network, "dest": r.Dest, "status": http.StatusCreated})
+		}
+
+		c.IndentedJSON(http.StatusOK, gin.H{"event": event, "delivered": delivered})
+	}
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ergochat/irc-go/ircevent"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func sign(secret, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestValidSignature(t *testing.T) {
+	payload := []byte(`{"zen": "Keep it logically awesome."}`)
+	signature := sign("secret", string(payload))
+
+	assert.True(t, validSignature("secret", payload, hubSignaturePrefix+signature))
+	assert.True(t, validSignature("secret", payload, signature))
+	assert.False(t, validSignature("other", payload, hubSignaturePrefix+signature))
+	assert.False(t, validSignature("secret", []byte(`{}`), hubSignaturePrefix+signature))
+	assert.False(t, validSignature("secret", payload, "sha256=zz"))
+	assert.False(t, validSignature("secret", payload, ""))
+}
+
+func TestFormatForgeEvent(t *testing.T) {
+	cases := map[string]struct {
+		event    string
+		payload  string
+		expected string
+		ignored  bool
+	}{
+		"push": {
+			event:    forgeEventPush,
+			payload:  `{"ref": "refs/heads/main", "compare": "https://github.com/gowon-irc/gowon/compare/a...b", "commits": [{"message": "one"}, {"message": "two\n\nbody"}], "head_commit": {"message": "two\n\nbody"}, "pusher": {"name": "alice"}, "repository": {"full_name": "gowon-irc/gowon"}}`,
+			expected: "[{cyan}gowon-irc/gowon{clear}] {bold}alice{bold} pushed 2 commits to {green}main{clear}: two https://github.com/gowon-irc/gowon/compare/a...b",
+		},
+		"gitea push": {
+			event:    forgeEventPush,
+			payload:  `{"ref": "refs/heads/dev", "compare_url": "https://gitea.example/o/r/compare/a...b", "commits": [{"message": "fix {red} tokens"}], "pusher": {"login": "bob", "username": "bob"}, "repository": {"full_name": "o/r"}}`,
+			expected: "[{cyan}o/r{clear}] {bold}bob{bold} pushed 1 commit to {green}dev{clear}: fix {{red} tokens https://gitea.example/o/r/compare/a...b",
+		},
+		"tag push": {
+			event:    forgeEventPush,
+			payload:  `{"ref": "refs/tags/v1.0.0", "created": true, "pusher": {"name": "alice"}, "repository": {"full_name": "o/r"}}`,
+			expected: "[{cyan}o/r{clear}] {bold}alice{bold} pushed tag {yellow}v1.0.0{clear}",
+		},
+		"branch deleted": {
+			event:    forgeEventPush,
+			payload:  `{"ref": "refs/heads/old", "deleted": true, "pusher": {"name": "alice"}, "repository": {"full_name": "o/r"}}`,
+			expected: "[{cyan}o/r{clear}] {bold}alice{bold} deleted branch {green}old{clear}",
+		},
+		"pull request opened": {
+			event:    forgeEventPullRequest,
+			payload:  `{"action": "opened", "pull_request": {"number": 12, "title": "Add thing", "html_url": "https://github.com/o/r/pull/12", "base": {"ref": "main"}}, "sender": {"login": "carol"}, "repository": {"full_name": "o/r"}}`,
+			expected: "[{cyan}o/r{clear}] {bold}carol{bold} {green}opened{clear} pull request #12: Add thing https://github.com/o/r/pull/12",
+		},
+		"pull request merged": {
+			event:    forgeEventPullRequest,
+			payload:  `{"action": "closed", "pull_request": {"number": 12, "title": "Add thing", "html_url": "https://github.com/o/r/pull/12", "merged": true}, "sender": {"login": "carol"}, "repository": {"full_name": "o/r"}}`,
+			expected: "[{cyan}o/r{clear}] {bold}carol{bold} {magenta}merged{clear} pull request #12: Add thing https://github.com/o/r/pull/12",
+		},
+		"pull request labeled": {
+			event:   forgeEventPullRequest,
+			payload: `{"action": "labeled", "pull_request": {"number": 12}, "repository": {"full_name": "o/r"}}`,
+			ignored: true,
+		},
+		"issue closed": {
+			event:    forgeEventIssues,
+			payload:  `{"action": "closed", "issue": {"number": 3, "title": "Broken", "html_url": "https://github.com/o/r/issues/3"}, "sender": {"login": "dave"}, "repository": {"full_name": "o/r"}}`,
+			expected: "[{cyan}o/r{clear}] {bold}dave{bold} {red}closed{clear} issue #3: Broken https://github.com/o/r/issues/3",
+		},
+		"release": {
+			event:    forgeEventRelease,
+			payload:  `{"action": "published", "release": {"tag_name": "v1.0.0", "html_url": "https://github.com/o/r/releases/v1.0.0"}, "sender": {"login": "erin"}, "repository": {"full_name": "o/r"}}`,
+			expected: "[{cyan}o/r{clear}] {bold}erin{bold} {green}published{clear} release {yellow}v1.0.0{clear} https://github.com/o/r/releases/v1.0.0",
+		},
+		"release drafted": {
+			event:   forgeEventRelease,
+			payload: `{"action": "created", "release": {"tag_name": "v1.0.0"}, "repository": {"full_name": "o/r"}}`,
+			ignored: true,
+		},
+		"unsupported event": {
+			event:   "star",
+			payload: `{"action": "created", "repository": {"full_name": "o/r"}}`,
+			ignored: true,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			var e forgeEvent
+			assert.Nil(t, json.Unmarshal([]byte(tc.payload), &e))
+
+			out, ok := formatForgeEvent(tc.event, &e)
+			assert.Equal(t, !tc.ignored, ok)
+			assert.Equal(t, tc.expected, out)
+		})
+	}
+}
+
+func TestForgeRouteMatch(t *testing.T) {
+	push := &forgeEvent{Ref: "refs/heads/main"}
+	push.Repository.FullName = "gowon-irc/gowon"
+
+	tag := &forgeEvent{Ref: "refs/tags/v1"}
+	tag.Repository.FullName = "gowon-irc/gowon"
+
+	pr := &forgeEvent{PullRequest: &forgeIssue{}}
+	pr.PullRequest.Base.Ref = "release-1"
+	pr.Repository.FullName = "gowon-irc/gowon"
+
+	issue := &forgeEvent{Issue: &forgeIssue{}}
+	issue.Repository.FullName = "gowon-irc/gowon"
+
+	cases := map[string]struct {
+		route    ForgeRoute
+		event    string
+		e        *forgeEvent
+		expected bool
+	}{
+		"repository": {
+			route:    ForgeRoute{Repository: "gowon-irc/gowon"},
+			event:    forgeEventPush,
+			e:        push,
+			expected: true,
+		},
+		"repository wildcard": {
+			route:    ForgeRoute{Repository: "gowon-irc/*"},
+			event:    forgeEventPush,
+			e:        push,
+			expected: true,
+		},
+		"other repository": {
+			route: ForgeRoute{Repository: "other/*"},
+			event: forgeEventPush,
+			e:     push,
+		},
+		"event filtered": {
+			route: ForgeRoute{Repository: "*", Events: []string{forgeEventRelease}},
+			event: forgeEventPush,
+			e:     push,
+		},
+		"event allowed": {
+			route:    ForgeRoute{Repository: "*", Events: []string{forgeEventPush, forgeEventRelease}},
+			event:    forgeEventPush,
+			e:        push,
+			expected: true,
+		},
+		"branch allowed": {
+			route:    ForgeRoute{Repository: "*", Branches: []string{"main"}},
+			event:    forgeEventPush,
+			e:        push,
+			expected: true,
+		},
+		"pull request base branch": {
+			route:    ForgeRoute{Repository: "*", Branches: []string{"release-*"}},
+			event:    forgeEventPullRequest,
+			e:        pr,
+			expected: true,
+		},
+		"branch filtered": {
+			route: ForgeRoute{Repository: "*", Branches: []string{"release-*"}},
+			event: forgeEventPush,
+			e:     push,
+		},
+		"tag with branch filter": {
+			route: ForgeRoute{Repository: "*", Branches: []string{"main"}},
+			event: forgeEventPush,
+			e:     tag,
+		},
+		"branch filter ignored for issues": {
+			route:    ForgeRoute{Repository: "*", Branches: []string{"main"}},
+			event:    forgeEventIssues,
+			e:        issue,
+			expected: true,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.route.Match(tc.event, tc.e))
+		})
+	}
+}
+
+func TestCreateForgeHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cm := NewConfigManager()
+	cm.MergedConfig = &Config{
+		ForgeSecret: "secret",
+		ForgeRoutes: []ForgeRoute{
+			{Repository: "gowon-irc/*", Dest: "#gowon", Branches: []string{"main"}},
+			{Repository: "gowon-irc/gowon", Dest: "#other"},
+		},
+	}
+
+	irccon := &ircevent.Connection{Nick: "gowon", User: "gowon"}
+	n := &Network{Name: defaultNetwork, irccon: irccon, cm: cm, chm: NewChannelManager(irccon)}
+	n.chm.setPresent("#gowon", true)
+
+	r := gin.New()
+	r.POST("/forge", createForgeHandler(cm, Networks{n}))
+
+	push := `{"ref": "refs/heads/main", "commits": [{"message": "fix"}], "pusher": {"name": "alice"}, "repository": {"full_name": "gowon-irc/gowon"}}`
+
+	cases := map[string]struct {
+		event     string
+		header    string
+		signature string
+		body      string
+		expected  int
+		delivered []int
+		errMsg    string
+	}{
+		"push": {
+			event:     forgeEventPush,
+			header:    hubSignatureHeader,
+			signature: hubSignaturePrefix + sign("secret", push),
+			body:      push,
+			expected:  http.StatusOK,
+			delivered: []int{http.StatusCreated, http.StatusForbidden},
+		},
+		"gitea signature": {
+			event:     forgeEventPush,
+			header:    giteaSignatureHeader,
+			signature: sign("secret", push),
+			body:      push,
+			expected:  http.StatusOK,
+			delivered: []int{http.StatusCreated, http.StatusForbidden},
+		},
+		"unprefixed hub signature": {
+			event:     forgeEventPush,
+			header:    hubSignatureHeader,
+			signature: sign("secret", push),
+			body:      push,
+			expected:  http.StatusUnauthorized,
+			errMsg:    invalidSignatureErrMsg,
+		},
+		"wrong signature": {
+			event:     forgeEventPush,
+			header:    hubSignatureHeader,
+			signature: hubSignaturePrefix + sign("other", push),
+			body:      push,
+			expected:  http.StatusUnauthorized,
+			errMsg:    invalidSignatureErrMsg,
+		},
+		"missing signature": {
+			event:    forgeEventPush,
+			body:     push,
+			expected: http.StatusUnauthorized,
+			errMsg:   invalidSignatureErrMsg,
+		},
+		"missing event": {
+			header:    hubSignatureHeader,
+			signature: hubSignaturePrefix + sign("secret", push),
+			body:      push,
+			expected:  http.StatusBadRequest,
+			errMsg:    missingEventErrMsg,
+		},
+		"ping": {
+			event:     forgeEventPing,
+			header:    hubSignatureHeader,
+			signature: hubSignaturePrefix + sign("secret", `{}`),
+			body:      `{}`,
+			expected:  http.StatusOK,
+		},
+		"ignored event": {
+			event:     "star",
+			header:    hubSignatureHeader,
+			signature: hubSignaturePrefix + sign("secret", push),
+			body:      push,
+			expected:  http.StatusOK,
+			delivered: []int{},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/forge", strings.NewReader(tc.body))
+			if tc.event != "" {
+				req.Header.Set(githubEventHeader, tc.event)
+			}
+			if tc.header != "" {
+				req.Header.Set(tc.header, tc.signature)
+			}
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expected, w.Code)
+			if tc.errMsg != "" {
+				assert.Contains(t, w.Body.String(), tc.errMsg)
+			}
+
+			if tc.delivered != nil {
+				var resp struct {
+					Delivered []struct {
+						Status int `json:"status"`
+					} `json:"delivered"`
+				}
+				assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+
+				statuses := []int{}
+				for _, d := range resp.Delivered {
+					statuses = append(statuses, d.Status)
+				}
+				assert.Equal(t, tc.delivered, statuses)
+			}
+		})
+	}
+}
+
+func TestCreateForgeHandlerNoSecret(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cm := NewConfigManager()
+	cm.MergedConfig = &Config{}
+
+	r := gin.New()
+	r.POST("/forge", createForgeHandler(cm, Networks{}))
+
+	req := httptest.NewRequest(http.MethodPost, "/forge", strings.NewReader(`{}`))
+	req.Header.Set(githubEventHeader, forgeEventPing)
+	req.Header.Set(hubSignatureHeader, hubSignaturePrefix+sign("", `{}`))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
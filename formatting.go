@@ -3,61 +3,211 @@ package main
 import (
 	"fmt"
 	"regexp"
+	"strconv"
 	"strings"
+
+	"github.com/rivo/uniseg"
 )
 
+const (
+	boldCode          = "\x02"
+	colourCode        = "\x03"
+	hexColourCode     = "\x04"
+	resetCode         = "\x0F"
+	monospaceCode     = "\x11"
+	reverseCode       = "\x16"
+	italicCode        = "\x1D"
+	strikethroughCode = "\x1E"
+	underlineCode     = "\x1F"
+
+	defaultColour = "99"
+)
+
+var formattingRegex = regexp.MustCompile("\x03(?:\\d{1,2}(?:,\\d{1,2})?)?|\x04(?:[0-9a-fA-F]{6}(?:,[0-9a-fA-F]{6})?)?|[\x02\x0F\x11\x16\x1D\x1E\x1F]")
+
+var leadingFormattingRegex = regexp.MustCompile("^(?:" + formattingRegex.String() + ")+")
+
+var tokenRegex = regexp.MustCompile(`\{\{|\{([a-z]+|\d{2}|#[0-9a-fA-F]{6})(?:,([a-z]+|\d{2}|#[0-9a-fA-F]{6}))?\}`)
+
+var colourNames = map[string]string{
+	"white":   "00",
+	"black":   "01",
+	"blue":    "02",
+	"green":   "03",
+	"red":     "04",
+	"brown":   "05",
+	"magenta": "06",
+	"orange":  "07",
+	"yellow":  "08",
+	"lgreen":  "09",
+	"cyan":    "10",
+	"lcyan":   "11",
+	"lblue":   "12",
+	"pink":    "13",
+	"grey":    "14",
+	"gray":    "14",
+	"lgrey":   "15",
+	"lgray":   "15",
+}
+
+var formattingTokens = map[string]string{
+	"bold":          boldCode,
+	"italic":        italicCode,
+	"underline":     underlineCode,
+	"strikethrough": strikethroughCode,
+	"monospace":     monospaceCode,
+	"reverse":       reverseCode,
+	"reset":         resetCode,
+	"clear":         colourCode + defaultColour,
+}
+
+func colourNumber(c string) (string, bool) {
+	if n, ok := colourNames[c]; ok {
+		return n, true
+	}
+
+	if _, err := strconv.Atoi(c); err == nil {
+		return c, true
+	}
+
+	return "", false
+}
+
+func replaceToken(token string) string {
+	if token == "{{" {
+		return "{"
+	}
+
+	m := tokenRegex.FindStringSubmatch(token)
+	fg, bg := m[1], m[2]
+
+	if code, ok := formattingTokens[fg]; ok && bg == "" {
+		return code
+	}
+
+	if strings.HasPrefix(fg, "#") {
+		switch {
+		case bg == "":
+			return hexColourCode + strings.ToUpper(fg[1:])
+		case strings.HasPrefix(bg, "#"):
+			return hexColourCode + strings.ToUpper(fg[1:]+","+bg[1:])
+		default:
+			return token
+		}
+	}
+
+	fc, ok := colourNumber(fg)
+	if !ok {
+		return token
+	}
+
+	if bg == "" {
+		return colourCode + fc
+	}
+
+	bc, ok := colourNumber(bg)
+	if !ok {
+		return token
+	}
+
+	return colourCode + fc + "," + bc
+}
+
 func colourMsg(msg string) string {
-	cs := [][]string{
-		{"white", "00"},
-		{"black", "01"},
-		{"blue", "02"},
-		{"green", "03"},
-		{"red", "04"},
-		{"brown", "05"},
-		{"magenta", "06"},
-		{"orange", "07"},
-		{"yellow", "08"},
-		{"lgreen", "09"},
-		{"cyan", "10"},
-		{"lcyan", "11"},
-		{"lblue", "12"},
-		{"pink", "13"},
-		{"grey", "14"},
-		{"gray", "14"},
-		{"lgrey", "15"},
-		{"lgray", "15"},
-		{"clear", "99"},
-	}
-
-	for _, c := range cs {
-		token := fmt.Sprintf("{%s}", c[0])
-		code := fmt.Sprintf("\x03%s", c[1])
-		msg = strings.ReplaceAll(msg, token, code)
+	return tokenRegex.ReplaceAllStringFunc(msg, replaceToken)
+}
+
+func stripFormatting(msg string) string {
+	return formattingRegex.ReplaceAllString(msg, "")
+}
+
+type formatState struct {
+	toggles map[string]bool
+	fg, bg  string
+	hex     string
+}
+
+func parseFormatting(msg string) formatState {
+	s := formatState{toggles: map[string]bool{}}
+
+	for _, code := range formattingRegex.FindAllString(msg, -1) {
+		switch code[:1] {
+		case resetCode:
+			s = formatState{toggles: map[string]bool{}}
+		case colourCode:
+			fg, bg, hasBg := strings.Cut(code[1:], ",")
+			if fg == "" {
+				s.fg, s.bg = "", ""
+				continue
+			}
+
+			s.fg = fg
+			if hasBg {
+				s.bg = bg
+			}
+
+			if s.bg == defaultColour {
+				s.bg = ""
+			}
+
+			if s.fg == defaultColour && s.bg == "" {
+				s.fg = ""
+			}
+		case hexColourCode:
+			s.hex = code[1:]
+		default:
+			s.toggles[code] = !s.toggles[code]
+		}
 	}
 
-	return msg
+	return s
 }
 
+var toggleCodes = []string{boldCode, italicCode, underlineCode, strikethroughCode, monospaceCode, reverseCode}
+
+// Returns the codes that restore the formatting in effect at the end of msg.
 func lastColour(msg string) (colour string) {
-	r, _ := regexp.Compile("\x03\\d{2}")
-	colours := r.FindAllString(msg, -1)
+	s := parseFormatting(msg)
 
-	if len(colours) == 0 {
-		return ""
+	for _, t := range toggleCodes {
+		if s.toggles[t] {
+			colour += t
+		}
 	}
 
-	c := colours[len(colours)-1]
+	if s.fg != "" {
+		colour += colourCode + s.fg
+		if s.bg != "" {
+			colour += "," + s.bg
+		}
+	}
 
-	if c == "\x0399" {
-		return ""
+	if s.hex != "" {
+		colour += hexColourCode + s.hex
 	}
 
-	return c
+	return colour
 }
 
+// Closes any formatting left open at the end of msg.
 func terminateColour(msg string) string {
-	if lastColour(msg) != "" {
-		return msg + "\x0399"
+	s := parseFormatting(msg)
+
+	for _, t := range toggleCodes {
+		if s.toggles[t] {
+			msg += t
+		}
+	}
+
+	if s.fg != "" {
+		msg += colourCode + defaultColour
+		if s.bg != "" {
+			msg += "," + defaultColour
+		}
+	}
+
+	if s.hex != "" {
+		msg += hexColourCode
 	}
 
 	return msg
@@ -73,7 +223,8 @@ func reverseWords(words []string) []string {
 }
 
 func chunkMsg(msg string, length int) (chunk, remaining string) {
-	msg = terminateColour(msg)
+	codes := leadingFormattingRegex.FindString(msg)
+	msg = terminateColour(lastColour(codes) + msg[len(codes):])
 	if len(msg) <= length {
 		return msg, ""
 	}
@@ -90,8 +241,62 @@ func chunkMsg(msg string, length int) (chunk, remaining string) {
 		}
 	}
 
-	terminated := terminateColour(msg)
-	return terminated[0:length], terminated[length:]
+	return cutMsg(msg, length)
+}
+
+// Returns the offsets msg can be cut at without splitting a grapheme cluster
+// or separating a colour code from the text it colours.
+func cutPoints(msg string) (out []int) {
+	codes := formattingRegex.FindAllStringIndex(msg, -1)
+
+	g := uniseg.NewGraphemes(msg)
+	for g.Next() {
+		_, end := g.Positions()
+		if end == len(msg) || !touchesCode(end, codes) {
+			out = append(out, end)
+		}
+	}
+
+	return out
+}
+
+func touchesCode(pos int, codes [][]int) bool {
+	for _, c := range codes {
+		if pos > c[0] && pos <= c[1] {
+			return true
+		}
+	}
+
+	return false
+}
+
+func cutMsg(msg string, length int) (chunk, remaining string) {
+	points := cutPoints(msg)
+	cut := points[0]
+
+	for _, p := range points[1:] {
+		if len(terminateColour(msg[:p])) > length {
+			break
+		}
+
+		cut = p
+	}
+
+	chunk = msg[:cut]
+	remaining = strings.TrimLeft(msg[cut:], " ")
+
+	out := terminateColour(chunk)
+	if len(out) > length {
+		// The carried formatting and its terminators leave no room for even
+		// one grapheme, so it goes out plain rather than over the length.
+		out = stripFormatting(chunk)
+	}
+
+	if formattingRegex.ReplaceAllString(remaining, "") == "" {
+		return out, ""
+	}
+
+	return out, lastColour(chunk) + remaining
 }
 
 func splitMsg(msg string, length int) (out []string) {
@@ -1,8 +1,15 @@
 package main
 
 import (
+	"math/rand"
+	"reflect"
+	"regexp"
+	"strings"
 	"testing"
+	"testing/quick"
+	"unicode/utf8"
 
+	"github.com/rivo/uniseg"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -27,6 +34,41 @@ func TestColourMsg(t *testing.T) {
 			in:       "{red}Hello {blue}world{clear}",
 			expected: "\x0304Hello \x0302world\x0399",
 		},
+		{
+			name:     "Formatting",
+			in:       "{bold}b{bold} {italic}i {underline}u {strikethrough}s {monospace}m {reverse}r{reset}",
+			expected: "\x02b\x02 \x1Di \x1Fu \x1Es \x11m \x16r\x0F",
+		},
+		{
+			name:     "Background colour",
+			in:       "{white,blue}Hello",
+			expected: "\x0300,02Hello",
+		},
+		{
+			name:     "Extended palette",
+			in:       "{52}Hello {04,88}world",
+			expected: "\x0352Hello \x0304,88world",
+		},
+		{
+			name:     "Hex colour",
+			in:       "{#ff0000}Hello {#00ff00,#000000}world",
+			expected: "\x04FF0000Hello \x0400FF00,000000world",
+		},
+		{
+			name:     "Escaped tokens",
+			in:       "{{red}Hello {{{red}world{{",
+			expected: "{red}Hello {\x0304world{",
+		},
+		{
+			name:     "Escaped JSON",
+			in:       `{{"a": {{"b": 1}}`,
+			expected: `{"a": {"b": 1}}`,
+		},
+		{
+			name:     "Unknown tokens",
+			in:       "{purple} {bold,red} {#ff0000,red} {}",
+			expected: "{purple} {bold,red} {#ff0000,red} {}",
+		},
 	}
 
 	for _, tc := range cases {
@@ -63,6 +105,36 @@ func TestLastColour(t *testing.T) {
 			in:       "Hello",
 			expected: "",
 		},
+		{
+			name:     "Background colour",
+			in:       "\x0300,02Hello",
+			expected: "\x0300,02",
+		},
+		{
+			name:     "Background colour kept",
+			in:       "\x0300,02Hello \x0304world",
+			expected: "\x0304,02",
+		},
+		{
+			name:     "Formatting",
+			in:       "\x02\x1DHello\x1D \x0304world",
+			expected: "\x02\x0304",
+		},
+		{
+			name:     "Reset",
+			in:       "\x02\x0304Hello\x0F world",
+			expected: "",
+		},
+		{
+			name:     "Hex colour",
+			in:       "\x04FF0000Hello",
+			expected: "\x04FF0000",
+		},
+		{
+			name:     "Hex colour cleared",
+			in:       "\x04FF0000Hello\x04 world",
+			expected: "",
+		},
 	}
 
 	for _, tc := range cases {
@@ -88,6 +160,21 @@ func TestTerminateColour(t *testing.T) {
 			in:       "\x0302Blue string\x0399",
 			expected: "\x0302Blue string\x0399",
 		},
+		{
+			name:     "Background colour",
+			in:       "\x0302,04Blue string",
+			expected: "\x0302,04Blue string\x0399,99",
+		},
+		{
+			name:     "Formatting",
+			in:       "\x02\x1FBold string",
+			expected: "\x02\x1FBold string\x02\x1F",
+		},
+		{
+			name:     "Hex colour",
+			in:       "\x04FF0000Red string",
+			expected: "\x04FF0000Red string\x04",
+		},
 	}
 
 	for _, tc := range cases {
@@ -97,6 +184,36 @@ func TestTerminateColour(t *testing.T) {
 	}
 }
 
+func TestStripFormatting(t *testing.T) {
+	cases := []struct {
+		name     string
+		in       string
+		expected string
+	}{
+		{
+			name:     "Colours",
+			in:       "\x0304Hello\x0399 \x0300,02world",
+			expected: "Hello world",
+		},
+		{
+			name:     "Formatting",
+			in:       "\x02bold\x02 \x1Ditalic\x0F \x04FF0000hex\x04",
+			expected: "bold italic hex",
+		},
+		{
+			name:     "No formatting",
+			in:       "Hello world",
+			expected: "Hello world",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, stripFormatting(tc.in))
+		})
+	}
+}
+
 func TestReverseWords(t *testing.T) {
 	cases := []struct {
 		name     string
@@ -224,6 +341,36 @@ func TestSplitMsg(t *testing.T) {
 			in:       "\x0302Blue words and also\x0399 some normal words",
 			expected: []string{"\x0302Blue words and\x0399", "\x0302also\x0399 some", "normal words"},
 		},
+		{
+			name:     "Long word",
+			in:       "abcdefghijklmnopqrstuvwxyz",
+			expected: []string{"abcdefghijklmnopqrst", "uvwxyz"},
+		},
+		{
+			name:     "CJK",
+			in:       "日本語のテキストはスペースがない",
+			expected: []string{"日本語のテキ", "ストはスペー", "スがない"},
+		},
+		{
+			name:     "Emoji",
+			in:       "👨‍👩‍👧👨‍👩‍👧",
+			expected: []string{"👨‍👩‍👧", "👨‍👩‍👧"},
+		},
+		{
+			name:     "Formatting carried",
+			in:       "\x02\x0304,02Bold red on blue words",
+			expected: []string{"\x02\x0304,02Bold\x02\x0399,99", "\x02\x0304,02red on\x02\x0399,99", "\x02\x0304,02blue\x02\x0399,99", "\x02\x0304,02words\x02\x0399,99"},
+		},
+		{
+			name:     "Coloured long word",
+			in:       "\x0302abcdefghijklmnopqrstuvwxyz",
+			expected: []string{"\x0302abcdefghijklmn\x0399", "\x0302opqrstuvwxyz\x0399"},
+		},
+		{
+			name:     "Formatting wider than the length",
+			in:       "\x0304\x04FF0000👨‍👩‍👧 red",
+			expected: []string{"👨‍👩‍👧", "\x0304\x04FF0000red\x0399\x04"},
+		},
 	}
 
 	for _, tc := range cases {
@@ -233,3 +380,100 @@ func TestSplitMsg(t *testing.T) {
 		})
 	}
 }
+
+type ircText string
+
+var ircTextTokens = []string{
+	"word", "a", "longerwordwithoutspaces", " ", " ", " ",
+	"日本語", "テキスト", "한국어", "👍", "👨‍👩‍👧", "🇬🇧", "e\u0301",
+	"\x0302", "\x0304", "\x0399", "\x0304,02", "\x0352", "\x04FF0000", "\x04",
+	"\x02", "\x1D", "\x1F", "\x1E", "\x11", "\x16", "\x0F",
+}
+
+func (ircText) Generate(r *rand.Rand, size int) reflect.Value {
+	var sb strings.Builder
+
+	for i := 0; i < r.Intn(size*4+1); i++ {
+		sb.WriteString(ircTextTokens[r.Intn(len(ircTextTokens))])
+	}
+
+	return reflect.ValueOf(ircText(sb.String()))
+}
+
+type splitLength int
+
+func (splitLength) Generate(r *rand.Rand, size int) reflect.Value {
+	return reflect.ValueOf(splitLength(minLineBudget + r.Intn(64)))
+}
+
+func visibleText(msg string) string {
+	return strings.Join(strings.Fields(stripFormatting(msg)), "")
+}
+
+func TestSplitMsgProperties(t *testing.T) {
+	properties := []struct {
+		name string
+		f    func(msg string, length int, chunks []string) bool
+	}{
+		{
+			name: "Chunks fit in the length",
+			f: func(msg string, length int, chunks []string) bool {
+				for _, c := range chunks {
+					if len(c) > length {
+						return false
+					}
+				}
+				return true
+			},
+		},
+		{
+			name: "Chunks are valid UTF-8",
+			f: func(msg string, length int, chunks []string) bool {
+				for _, c := range chunks {
+					if !utf8.ValidString(c) {
+						return false
+					}
+				}
+				return true
+			},
+		},
+		{
+			name: "Colour codes are not split",
+			f: func(msg string, length int, chunks []string) bool {
+				colourCodes := regexp.MustCompile("\x03\\d{2}(,\\d{2})?|\x04([0-9A-F]{6}(,[0-9A-F]{6})?)?")
+				for _, c := range chunks {
+					if strings.Count(c, "\x03")+strings.Count(c, "\x04") != len(colourCodes.FindAllString(c, -1)) {
+						return false
+					}
+				}
+				return true
+			},
+		},
+		{
+			name: "Grapheme clusters are not split",
+			f: func(msg string, length int, chunks []string) bool {
+				n := 0
+				for _, c := range chunks {
+					n += uniseg.GraphemeClusterCount(visibleText(c))
+				}
+				return n == uniseg.GraphemeClusterCount(visibleText(msg))
+			},
+		},
+		{
+			name: "Text is preserved",
+			f: func(msg string, length int, chunks []string) bool {
+				return visibleText(strings.Join(chunks, "")) == visibleText(msg)
+			},
+		},
+	}
+
+	for _, p := range properties {
+		t.Run(p.name, func(t *testing.T) {
+			f := func(msg ircText, length splitLength) bool {
+				return p.f(string(msg), int(length), splitMsg(string(msg), int(length)))
+			}
+
+			assert.Nil(t, quick.Check(f, &quick.Config{MaxCount: 500}))
+		})
+	}
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ergochat/irc-go/ircmsg"
+	"github.com/gowon-irc/gowon/pkg/message"
+	"github.com/gowon-irc/gowon/pkg/module"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+const (
+	commandTypeGrpc = "grpc"
+
+	manifestTimeout = 5 * time.Second
+)
+
+func toProto(m *message.Message) *module.Message {
+	return &module.Message{
+		Module:    m.Module,
+		Msg:       m.Msg,
+		Nick:      m.Nick,
+		Dest:      m.Dest,
+		Command:   m.Command,
+		Args:      m.Args,
+		Network:   m.Network,
+		Code:      m.Code,
+		Raw:       m.Raw,
+		Host:      m.Host,
+		Source:    m.Source,
+		User:      m.User,
+		Arguments: m.Arguments,
+		Tags:      m.Tags,
+		Plain:     m.Plain,
+		Format:    m.Format,
+	}
+}
+
+func fromProto(m *module.Message) *message.Message {
+	return &message.Message{
+		Module:    m.GetModule(),
+		Msg:       m.GetMsg(),
+		Nick:      m.GetNick(),
+		Dest:      m.GetDest(),
+		Command:   m.GetCommand(),
+		Args:      m.GetArgs(),
+		Network:   m.GetNetwork(),
+		Code:      m.GetCode(),
+		Raw:       m.GetRaw(),
+		Host:      m.GetHost(),
+		Source:    m.GetSource(),
+		User:      m.GetUser(),
+		Arguments: m.GetArguments(),
+		Tags:      m.GetTags(),
+		Plain:     m.GetPlain(),
+		Format:    m.GetFormat(),
+	}
+}
+
+type GrpcCommand struct {
+	HttpCommand
+	module *grpcModule
+}
+
+func (gc *GrpcCommand) Send(in *message.Message) *message.Message {
+	ctx, cancel := context.WithTimeout(context.Background(), moduleTimeout)
+	defer cancel()
+
+	out, err := gc.module.client.Send(ctx, toProto(in))
+	if err != nil {
+		log.Printf("Command %s failed: %s", in.Command, err)
+		in.Msg = fmt.Sprintf("{red}Error: request to %s failed{clear}", in.Command)
+		return in
+	}
+
+	if out.GetMsg() == "" {
+		return nil
+	}
+
+	return fromProto(out)
+}
+
+// GetHelp answers from the help fetched when the module was last refreshed,
+// so asking for help never waits on the module.
+func (gc *GrpcCommand) GetHelp() string {
+	if gc.Help != "" {
+		return fmt.Sprintf("{cyan}%s{clear}: %s", gc.Command, gc.Help)
+	}
+
+	help, ok := gc.module.commandHelp(gc.Command)
+	if !ok {
+		return fmt.Sprintf("{cyan}%s{clear}: could not fetch help", gc.Command)
+	}
+
+	if help == "" {
+		return fmt.Sprintf("{cyan}%s{clear}: no help found", gc.Command)
+	}
+
+	return fmt.Sprintf("{cyan}%s{clear}: %s", gc.Command, help)
+}
+
+type grpcModule struct {
+	endpoint string
+	conn     *grpc.ClientConn
+	client   module.ModuleClient
+	events   chan *module.Message
+
+	mu       sync.Mutex
+	manifest *module.ManifestReply
+	help     map[string]string
+}
+
+func newGrpcModule(endpoint string) (*grpcModule, error) {
+	conn, err := grpc.NewClient(endpoint, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, err
+	}
+
+	gm := &grpcModule{
+		endpoint: endpoint,
+		conn:     conn,
+		client:   module.NewModuleClient(conn),
+		events:   make(chan *module.Message, eventBufferSize),
+		help:     make(map[string]string),
+	}
+
+	go gm.streamEvents()
+
+	return gm, nil
+}
+
+// refresh fetches the module's manifest and the help for the named commands,
+// keeping what it had before for anything that can't be fetched.
+func (gm *grpcModule) refresh(names []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), manifestTimeout)
+	defer cancel()
+
+	manifest, err := gm.client.Manifest(ctx, &module.ManifestRequest{})
+	if err != nil {
+		log.Printf("Could not fetch manifest from grpc module %s: %s", gm.endpoint, err)
+	} else {
+		gm.mu.Lock()
+		gm.manifest = manifest
+		gm.mu.Unlock()
+	}
+
+	for _, name := range names {
+		out, err := gm.client.Help(ctx, &module.HelpRequest{Command: name})
+		if err != nil {
+			log.Printf("Could not fetch help for %s from grpc module %s: %s", name, gm.endpoint, err)
+			continue
+		}
+
+		gm.mu.Lock()
+		gm.help[name] = out.GetHelp()
+		gm.mu.Unlock()
+	}
+}
+
+func (gm *grpcModule) commandHelp(name string) (string, bool) {
+	gm.mu.Lock()
+	defer gm.mu.Unlock()
+
+	help, ok := gm.help[name]
+
+	return help, ok
+}
+
+func (gm *grpcModule) manifestCommands() []*module.Command {
+	gm.mu.Lock()
+	defer gm.mu.Unlock()
+
+	return gm.manifest.GetCommands()
+}
+
+func (gm *grpcModule) wants(code string) bool {
+	gm.mu.Lock()
+	defer gm.mu.Unlock()
+
+	for _, e := range gm.manifest.GetEvents() {
+		if strings.EqualFold(e, code) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (gm *grpcModule) streamEvents() {
+	var stream module.Module_EventsClient
+
+	for m := range gm.events {
+		if stream == nil {
+			s, err := gm.client.Events(context.Background())
+			if err != nil {
+				log.Printf("Could not stream events to grpc module %s: %s", gm.endpoint, err)
+				continue
+			}
+
+			stream = s
+		}
+
+		if err := stream.Send(m); err != nil {
+			log.Printf("Could not stream events to grpc module %s: %s", gm.endpoint, err)
+			stream = nil
+		}
+	}
+
+	if stream != nil {
+		if _, err := stream.CloseAndRecv(); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+func (gm *grpcModule) command(cmd *Command) *GrpcCommand {
+	return &GrpcCommand{
+		HttpCommand: HttpCommand{
+			Command:  cmd.Command,
+			Endpoint: gm.endpoint,
+			Regex:    cmd.Regex,
+			Help:     cmd.Help,
+			Priority: cmd.Priority,
+		},
+		module: gm,
+	}
+}
+
+func (gm *grpcModule) close() {
+	close(gm.events)
+
+	if err := gm.conn.Close(); err != nil {
+		log.Println(err)
+	}
+}
+
+type GrpcHub struct {
+	mu       sync.Mutex
+	modules  map[string]*grpcModule
+	onChange func()
+
+	// refreshes are run one at a time so an older config can't bring back
+	// a module a newer one pruned
+	refreshMu sync.Mutex
+}
+
+func NewGrpcHub() *GrpcHub {
+	return &GrpcHub{
+		modules: make(map[string]*grpcModule),
+	}
+}
+
+func (h *GrpcHub) OnChange(f func()) {
+	h.mu.Lock()
+	h.onChange = f
+	h.mu.Unlock()
+}
+
+func (h *GrpcHub) module(endpoint string) (*grpcModule, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if gm, ok := h.modules[endpoint]; ok {
+		return gm, nil
+	}
+
+	gm, err := newGrpcModule(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	h.modules[endpoint] = gm
+
+	return gm, nil
+}
+
+// grpcCommandNames maps each grpc endpoint in commands to the names of the
+// commands configured for it, which need their help fetched.
+func grpcCommandNames(commands []Command) map[string][]string {
+	out := map[string][]string{}
+
+	for _, c := range commands {
+		if c.Type != commandTypeGrpc {
+			continue
+		}
+
+		if c.Command != "" && c.Help == "" {
+			out[c.Endpoint] = append(out[c.Endpoint], c.Command)
+		} else if _, ok := out[c.Endpoint]; !ok {
+			out[c.Endpoint] = nil
+		}
+	}
+
+	return out
+}
+
+// Refresh closes the modules that are no longer configured and fetches the
+// manifests of the rest. It can be slow, so it is run in the background and
+// calls the OnChange func once the router needs rebuilding.
+func (h *GrpcHub) Refresh(commands []Command) {
+	h.refreshMu.Lock()
+	defer h.refreshMu.Unlock()
+
+	endpoints := grpcCommandNames(commands)
+
+	h.mu.Lock()
+	for endpoint, gm := range h.modules {
+		if _, ok := endpoints[endpoint]; !ok {
+			gm.close()
+			delete(h.modules, endpoint)
+		}
+	}
+	h.mu.Unlock()
+
+	for endpoint, names := range endpoints {
+		gm, err := h.module(endpoint)
+		if err != nil {
+			log.Printf("Could not connect to grpc module %s: %s", endpoint, err)
+			continue
+		}
+
+		gm.refresh(names)
+	}
+
+	h.mu.Lock()
+	f := h.onChange
+	h.mu.Unlock()
+
+	if f != nil {
+		f()
+	}
+}
+
+// Commands builds router commands for the grpc entries in commands. A command
+// without a name is expanded into every command in its module's manifest, as
+// of the last refresh.
+func (h *GrpcHub) Commands(commands []Command) []RouterCommand {
+	out := []RouterCommand{}
+
+	for _, c := range commands {
+		if c.Type != commandTypeGrpc {
+			continue
+		}
+
+		gm, err := h.module(c.Endpoint)
+		if err != nil {
+			log.Printf("Skipping grpc command %s: %s", c.Command, err)
+			continue
+		}
+
+		if c.Command != "" {
+			out = append(out, gm.command(&c))
+			continue
+		}
+
+		for _, mc := range gm.manifestCommands() {
+			cmd := Command{
+				Command:  mc.GetCommand(),
+				Regex:    mc.GetRegex(),
+				Help:     mc.GetHelp(),
+				Priority: int(mc.GetPriority()),
+			}
+
+			if err := checkModuleCommand(cmd); err != nil {
+				log.Printf("Skipping command from grpc module %s: %s", gm.endpoint, err)
+				continue
+			}
+
+			out = append(out, gm.command(&cmd))
+		}
+	}
+
+	return out
+}
+
+func (h *GrpcHub) Publish(m *message.Message) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, gm := range h.modules {
+		if !gm.wants(m.Code) {
+			continue
+		}
+
+		select {
+		case gm.events <- toProto(m):
+		default:
+			log.Printf("Grpc module %s is not keeping up, dropping %s event", gm.endpoint, m.Code)
+		}
+	}
+}
+
+func (h *GrpcHub) Watch(n *Network) {
+	for _, e := range streamedEvents {
+		n.irccon.AddCallback(e, func(event ircmsg.Message) {
+			h.Publish(createMessage(n, event))
+		})
+	}
+}
+
+func (h *GrpcHub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for endpoint, gm := range h.modules {
+		gm.close()
+		delete(h.modules, endpoint)
+	}
+}
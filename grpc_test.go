@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gowon-irc/gowon/pkg/message"
+	"github.com/gowon-irc/gowon/pkg/module"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+)
+
+type testModule struct {
+	module.UnimplementedModuleServer
+	events   chan *module.Message
+	commands []*module.Command
+}
+
+func (tm *testModule) Send(_ context.Context, in *module.Message) (*module.Message, error) {
+	if in.GetArgs() == "" {
+		return &module.Message{}, nil
+	}
+
+	return &module.Message{Module: "echo", Msg: in.GetArgs(), Dest: in.GetDest()}, nil
+}
+
+func (tm *testModule) Help(_ context.Context, in *module.HelpRequest) (*module.HelpReply, error) {
+	return &module.HelpReply{Help: "repeats " + in.GetCommand()}, nil
+}
+
+func (tm *testModule) Manifest(context.Context, *module.ManifestRequest) (*module.ManifestReply, error) {
+	return &module.ManifestReply{
+		Name: "echo",
+		Commands: append([]*module.Command{
+			{Command: "echo"},
+			{Command: "say", Help: "say things", Priority: 2},
+		}, tm.commands...),
+		Events: []string{"JOIN"},
+	}, nil
+}
+
+func (tm *testModule) Events(stream module.Module_EventsServer) error {
+	for {
+		m, err := stream.Recv()
+		if err == io.EOF {
+			return stream.SendAndClose(&module.EventsReply{})
+		}
+		if err != nil {
+			return err
+		}
+
+		tm.events <- m
+	}
+}
+
+func serveTestModule(t *testing.T) (*testModule, string, func()) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	tm := &testModule{events: make(chan *module.Message, 10)}
+
+	srv := grpc.NewServer()
+	module.RegisterModuleServer(srv, tm)
+
+	go func() {
+		_ = srv.Serve(ln)
+	}()
+
+	return tm, ln.Addr().String(), srv.Stop
+}
+
+func TestProtoRoundTrip(t *testing.T) {
+	m := &message.Message{
+		Module:    "gowon",
+		Msg:       ".echo hi",
+		Nick:      "nick",
+		Dest:      "#gowon",
+		Command:   "echo",
+		Args:      "hi",
+		Network:   "libera",
+		Code:      "PRIVMSG",
+		Arguments: []string{"#gowon", ".echo hi"},
+		Tags:      map[string]string{"msgid": "abc"},
+		Plain:     true,
+		Format:    message.FormatMarkdown,
+	}
+
+	assert.Equal(t, m, fromProto(toProto(m)))
+}
+
+func TestGrpcHub(t *testing.T) {
+	tm, addr, stop := serveTestModule(t)
+	defer stop()
+
+	h := NewGrpcHub()
+	defer h.Close()
+
+	commands := []Command{
+		{Command: "repeat", Type: commandTypeGrpc, Endpoint: addr},
+		{Type: commandTypeGrpc, Endpoint: addr},
+		{Command: "weather", Endpoint: "http://weather:8080"},
+	}
+
+	// manifests are only fetched by a refresh
+	assert.Len(t, h.Commands(commands), 1)
+
+	refreshed := make(chan bool, 1)
+	h.OnChange(func() { refreshed <- true })
+	h.Refresh(commands)
+	assert.True(t, <-refreshed)
+
+	cmds := h.Commands(commands)
+
+	names := []string{}
+	for _, c := range cmds {
+		names = append(names, c.GetCommand())
+	}
+	assert.Equal(t, []string{"repeat", "echo", "say"}, names)
+
+	assert.Equal(t, "{cyan}repeat{clear}: repeats repeat", cmds[0].GetHelp())
+	assert.Equal(t, "{cyan}say{clear}: say things", cmds[2].GetHelp())
+	assert.Equal(t, 2, cmds[2].GetPriority())
+
+	out := cmds[1].Send(&message.Message{Msg: ".echo hi", Dest: "#gowon", Command: "echo", Args: "hi"})
+	assert.Equal(t, "hi", out.Msg)
+	assert.Equal(t, "#gowon", out.Dest)
+
+	assert.Nil(t, cmds[1].Send(&message.Message{Msg: ".echo", Dest: "#gowon", Command: "echo"}))
+
+	h.Publish(&message.Message{Code: "PRIVMSG", Dest: "#gowon"})
+	h.Publish(&message.Message{Code: "JOIN", Dest: "#gowon", Nick: "nick"})
+
+	select {
+	case m := <-tm.events:
+		assert.Equal(t, "JOIN", m.GetCode())
+		assert.Equal(t, "nick", m.GetNick())
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event streamed to module")
+	}
+}
+
+func TestGrpcCommandUnreachable(t *testing.T) {
+	h := NewGrpcHub()
+	defer h.Close()
+
+	commands := []Command{{Command: "echo", Type: commandTypeGrpc, Endpoint: "127.0.0.1:1"}}
+	h.Refresh(commands)
+
+	cmds := h.Commands(commands)
+	assert.Len(t, cmds, 1)
+
+	out := cmds[0].Send(&message.Message{Msg: ".echo hi", Dest: "#gowon", Command: "echo", Args: "hi"})
+	assert.Equal(t, "{red}Error: request to echo failed{clear}", out.Msg)
+	assert.Equal(t, "{cyan}echo{clear}: could not fetch help", cmds[0].GetHelp())
+}
+
+func TestGrpcHubPrunes(t *testing.T) {
+	_, addr, stop := serveTestModule(t)
+	defer stop()
+
+	h := NewGrpcHub()
+	defer h.Close()
+
+	h.Refresh([]Command{{Type: commandTypeGrpc, Endpoint: addr}, {Type: commandTypeGrpc, Endpoint: "127.0.0.1:1"}})
+	assert.Len(t, h.modules, 2)
+
+	h.Refresh([]Command{{Type: commandTypeGrpc, Endpoint: addr}})
+	assert.Len(t, h.modules, 1)
+	assert.Contains(t, h.modules, addr)
+
+	h.Refresh(nil)
+	assert.Len(t, h.modules, 0)
+}
+
+func TestGrpcHubSkipsInvalidCommands(t *testing.T) {
+	tm, addr, stop := serveTestModule(t)
+	defer stop()
+
+	tm.commands = []*module.Command{
+		{Regex: ".*"},
+		{Command: "broken", Regex: "("},
+	}
+
+	h := NewGrpcHub()
+	defer h.Close()
+
+	commands := []Command{{Type: commandTypeGrpc, Endpoint: addr}}
+	h.Refresh(commands)
+
+	names := []string{}
+	for _, c := range h.Commands(commands) {
+		names = append(names, c.GetCommand())
+	}
+	assert.Equal(t, []string{"echo", "say"}, names)
+}
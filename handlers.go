@@ -1,54 +1,97 @@
 package main
 
 import (
+	"encoding/json"
 	"log"
 	"net/http"
+	"regexp"
 	"strings"
 
-	"github.com/ergochat/irc-go/ircevent"
 	"github.com/ergochat/irc-go/ircmsg"
 	"github.com/gin-gonic/gin"
-	"github.com/gowon-irc/go-gowon"
+	"github.com/gowon-irc/gowon/pkg/message"
 )
 
-func createIrcHandler(irccon *ircevent.Connection, cr *CommandRouter) func(event ircmsg.Message) {
-	return func(event ircmsg.Message) {
-		nuh, err := ircmsg.ParseNUH(event.Source)
-		if err != nil {
-			log.Println(err)
+const (
+	invalidDestErrMsg = "destination is not a valid channel or nick"
+	notJoinedErrMsg   = "not in channel and destination is not in allowed_destinations"
+)
+
+// The number of params an event needs before its last param is message text.
+var textEvents = map[string]int{
+	"NOTICE": 2,
+	"TOPIC":  2,
+	"PART":   2,
+	"KICK":   3,
+	"QUIT":   1,
+	"AWAY":   1,
+}
+
+func createMessage(n *Network, event ircmsg.Message) *message.Message {
+	nuh, err := ircmsg.ParseNUH(event.Source)
+	if err != nil {
+		log.Println(err)
+	}
+
+	line, err := event.Line()
+	if err != nil {
+		log.Println(err)
+	}
+
+	var msg, dest, command, args string
+
+	switch {
+	case event.Command == "PRIVMSG":
+		msg = event.Params[1]
+		dest = event.Params[0]
+		command = message.GetCommand(msg)
+		args = message.GetArgs(msg)
+	case len(event.Params) > 0:
+		if checkChannel(event.Params[0]) == nil {
+			dest = event.Params[0]
 		}
 
-		line, err := event.Line()
-		if err != nil {
-			log.Println(err)
+		if p, ok := textEvents[event.Command]; ok && len(event.Params) >= p {
+			msg = event.Params[len(event.Params)-1]
 		}
+	}
 
-		var msg, dest, command, args string
+	return &message.Message{
+		Module:    "gowon",
+		Nick:      event.Nick(),
+		Code:      event.Command,
+		Raw:       line,
+		Host:      nuh.Host,
+		Source:    event.Source,
+		User:      nuh.User,
+		Arguments: event.Params,
+		Tags:      event.AllTags(),
+		Msg:       msg,
+		Dest:      dest,
+		Command:   command,
+		Args:      args,
+		Network:   n.Name,
+	}
+}
 
-		if event.Command == "PRIVMSG" {
-			msg = event.Params[1]
-			dest = event.Params[0]
-			command = gowon.GetCommand(event.Params[1])
-			args = gowon.GetArgs(event.Params[1])
-		}
-
-		m := &gowon.Message{
-			Module:    "gowon",
-			Nick:      event.Nick(),
-			Code:      event.Command,
-			Raw:       line,
-			Host:      nuh.Host,
-			Source:    event.Source,
-			User:      nuh.User,
-			Arguments: event.Params,
-			Tags:      event.AllTags(),
-			Msg:       msg,
-			Dest:      dest,
-			Command:   command,
-			Args:      args,
-		}
-
-		rc, err := cr.Route(msg)
+func createIrcHandler(n *Network, cr *CommandRouter, ps PasteStore) func(event ircmsg.Message) {
+	return func(event ircmsg.Message) {
+		if strings.EqualFold(event.Nick(), n.irccon.CurrentNick()) {
+			return
+		}
+
+		m := createMessage(n, event)
+
+		// commands addressed to the bot by nick route as if they were
+		// prefixed, and modules get the command and args from that, with
+		// the message left as it was sent
+		text, addressed := addressedMessage(m.Msg, n.nm.Nicks())
+		if addressed {
+			m.Command = message.GetCommand(text)
+			m.Args = message.GetArgs(text)
+		}
+
+		rc, err := cr.RouteMessage(text, m)
 		if err != nil {
 			return
 		}
@@ -59,36 +102,156 @@ func createIrcHandler(irccon *ircevent.Connection, cr *CommandRouter) func(event
 			return
 		}
 
-		for _, line := range strings.Split(output.Msg, "\n") {
-			coloured := colourMsg(line)
-			for _, sm := range splitMsg(coloured, 400) {
-				err := irccon.Privmsg(output.Dest, sm)
-				if err != nil {
-					log.Println(err)
-				}
-			}
+		msg := overflowMsg(messageText(output), n.cm.Config().MaxLines, n.lineBudget(output.Dest), ps)
+		n.SendMessage(output.Dest, msg, output.Tags)
+	}
+}
+
+func messageText(m *message.Message) string {
+	if m.Format == message.FormatMarkdown {
+		return markdownMsg(m.Msg)
+	}
+
+	if m.Plain {
+		return message.EscapeTokens(m.Msg)
+	}
+
+	return m.Msg
+}
+
+func messageErrorStatus(err error) int {
+	switch err.Error() {
+	case message.ErrorMessageNoModuleMsg, message.ErrorMessageNoBodyMsg, message.ErrorMessageNoDestinationMsg, message.ErrorMessageInvalidFormatMsg:
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+func validDest(dest string) bool {
+	return regexp.MustCompile(ircChannelRegex).MatchString(dest) || regexp.MustCompile(ircNickRegex).MatchString(dest)
+}
+
+func deliverMessage(networks Networks, token *ApiToken, body []byte) (*message.Message, int, gin.H) {
+	m, err := message.CreateMessageStruct(body)
+	if err != nil {
+		return nil, messageErrorStatus(err), gin.H{"error": err.Error()}
+	}
+
+	if !validDest(m.Dest) {
+		return nil, http.StatusUnprocessableEntity, gin.H{"dest": m.Dest, "error": invalidDestErrMsg}
+	}
+
+	n, err := networks.Get(m.Network)
+	if err != nil {
+		return nil, http.StatusBadRequest, gin.H{"network": m.Network, "error": err.Error()}
+	}
+
+	if kind := messageKind(m.Msg); token != nil && !token.Allowed(kind, n.Name, m.Dest) {
+		return nil, http.StatusForbidden, gin.H{"token": token.Name, "kind": kind, "network": n.Name, "dest": m.Dest, "error": forbiddenErrMsg}
+	}
+
+	if !n.chm.In(m.Dest) && !matchMask(m.Dest, n.cm.Config().AllowedDestinations) {
+		return nil, http.StatusForbidden, gin.H{"network": n.Name, "dest": m.Dest, "error": notJoinedErrMsg}
+	}
+
+	m.Network = n.Name
+	n.SendMessage(m.Dest, messageText(&m), m.Tags)
+
+	return &m, http.StatusCreated, nil
+}
+
+func createHttpHandler(networks Networks) func(*gin.Context) {
+	return func(c *gin.Context) {
+		body, err := c.GetRawData()
+		if err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		m, status, errBody := deliverMessage(networks, contextToken(c), body)
+		if errBody != nil {
+			c.IndentedJSON(status, errBody)
+			return
 		}
+
+		c.IndentedJSON(status, m)
 	}
 }
 
-func createHttpHandler(irccon *ircevent.Connection) func(*gin.Context) {
+func createChannelHandler(networks Networks, kind string, f func(n *Network, channel, arg string) error, param string) func(*gin.Context) {
 	return func(c *gin.Context) {
-		var m gowon.Message
+		channel := normaliseChannel(c.Param("name"))
+
+		n, err := networks.Get(c.Query("network"))
+		if err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"channel": channel, "error": err.Error()})
+			return
+		}
+
+		if !authorised(c, kind, n.Name, channel) {
+			return
+		}
+
+		// keys are read from the body so they stay out of request logs
+		args := map[string]string{}
+
+		body, err := c.GetRawData()
+		if err == nil && len(body) > 0 {
+			err = json.Unmarshal(body, &args)
+		}
+
+		if err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"network": n.Name, "channel": channel, "error": err.Error()})
+			return
+		}
+
+		if err := f(n, channel, args[param]); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"network": n.Name, "channel": channel, "error": err.Error()})
+			return
+		}
 
-		if err := c.BindJSON(&m); err != nil {
+		c.IndentedJSON(http.StatusOK, gin.H{"network": n.Name, "channel": channel})
+	}
+}
+
+func createInviteHandler(cm *ConfigManager, n *Network) func(event ircmsg.Message) {
+	return func(event ircmsg.Message) {
+		if len(event.Params) < 2 {
 			return
 		}
 
-		for _, line := range strings.Split(m.Msg, "\n") {
-			coloured := colourMsg(line)
-			for _, sm := range splitMsg(coloured, 400) {
-				err := irccon.Privmsg(m.Dest, sm)
-				if err != nil {
-					log.Println(err)
-				}
-			}
+		cfg := cm.Config()
+		channel := event.Params[1]
+
+		if !acceptInvite(cfg.OnInvite, event.Source, cfg.Admins) {
+			log.Printf("Ignoring invite to %s from %s", channel, event.Source)
+			return
+		}
+
+		log.Printf("Accepting invite to %s from %s", channel, event.Source)
+
+		if err := n.chm.Join(channel, ""); err != nil {
+			log.Println(err)
+			return
+		}
+
+		if cfg.InvitesFile == "" {
+			return
+		}
+
+		if err := persistChannel(cfg.InvitesFile, n.Name, channel); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+func createStatusHandler(networks Networks) func(*gin.Context) {
+	return func(c *gin.Context) {
+		if !authorisedKind(c, kindStatus) {
+			return
 		}
 
-		c.IndentedJSON(http.StatusCreated, m)
+		c.IndentedJSON(http.StatusOK, networks.Statuses())
 	}
 }
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ergochat/irc-go/ircevent"
+	"github.com/ergochat/irc-go/ircmsg"
+	"github.com/gin-gonic/gin"
+	"github.com/gowon-irc/gowon/pkg/message"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateHttpHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cm := NewConfigManager()
+	cm.MergedConfig = &Config{AllowedDestinations: []string{"#announce*", "ops"}}
+
+	irccon := &ircevent.Connection{Nick: "gowon", User: "gowon"}
+	n := &Network{Name: defaultNetwork, irccon: irccon, cm: cm, chm: NewChannelManager(irccon)}
+	n.chm.setPresent("#gowon", true)
+
+	r := gin.New()
+	r.POST("/message", createHttpHandler(Networks{n}))
+
+	cases := map[string]struct {
+		body     string
+		expected int
+		errMsg   string
+	}{
+		"joined channel": {
+			body:     `{"module": "test", "msg": "hello", "dest": "#gowon"}`,
+			expected: http.StatusCreated,
+		},
+		"allowed channel": {
+			body:     `{"module": "test", "msg": "hello", "dest": "#announcements"}`,
+			expected: http.StatusCreated,
+		},
+		"allowed nick": {
+			body:     `{"module": "test", "msg": "hello", "dest": "ops"}`,
+			expected: http.StatusCreated,
+		},
+		"not joined": {
+			body:     `{"module": "test", "msg": "hello", "dest": "#other"}`,
+			expected: http.StatusForbidden,
+			errMsg:   notJoinedErrMsg,
+		},
+		"invalid json": {
+			body:     `{"module": `,
+			expected: http.StatusBadRequest,
+		},
+		"missing dest": {
+			body:     `{"module": "test", "msg": "hello"}`,
+			expected: http.StatusUnprocessableEntity,
+			errMsg:   message.ErrorMessageNoDestinationMsg,
+		},
+		"invalid dest": {
+			body:     `{"module": "test", "msg": "hello", "dest": "#gowon\r\nQUIT"}`,
+			expected: http.StatusUnprocessableEntity,
+			errMsg:   invalidDestErrMsg,
+		},
+		"unknown network": {
+			body:     `{"module": "test", "msg": "hello", "dest": "#gowon", "network": "oftc"}`,
+			expected: http.StatusBadRequest,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/message", strings.NewReader(tc.body))
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expected, w.Code)
+			if tc.errMsg != "" {
+				assert.Contains(t, w.Body.String(), tc.errMsg)
+			}
+		})
+	}
+}
+
+func TestCreateMessageEvents(t *testing.T) {
+	n := &Network{Name: "libera", irccon: &ircevent.Connection{}}
+
+	cases := map[string]struct {
+		event ircmsg.Message
+		dest  string
+		msg   string
+	}{
+		"join": {
+			event: ircmsg.MakeMessage(nil, "nick!user@host", "JOIN", "#gowon"),
+			dest:  "#gowon",
+		},
+		"part without reason": {
+			event: ircmsg.MakeMessage(nil, "nick!user@host", "PART", "#gowon"),
+			dest:  "#gowon",
+		},
+		"part with reason": {
+			event: ircmsg.MakeMessage(nil, "nick!user@host", "PART", "#gowon", "bye"),
+			dest:  "#gowon",
+			msg:   "bye",
+		},
+		"kick": {
+			event: ircmsg.MakeMessage(nil, "op!user@host", "KICK", "#gowon", "nick", "spam"),
+			dest:  "#gowon",
+			msg:   "spam",
+		},
+		"quit": {
+			event: ircmsg.MakeMessage(nil, "nick!user@host", "QUIT", "gone"),
+			msg:   "gone",
+		},
+		"nick": {
+			event: ircmsg.MakeMessage(nil, "nick!user@host", "NICK", "newnick"),
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			m := createMessage(n, tc.event)
+
+			assert.Equal(t, tc.event.Command, m.Code)
+			assert.Equal(t, tc.event.Nick(), m.Nick)
+			assert.Equal(t, tc.dest, m.Dest)
+			assert.Equal(t, tc.msg, m.Msg)
+			assert.Equal(t, "libera", m.Network)
+		})
+	}
+}
+
+func TestCreateChannelHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cm := NewConfigManager()
+	cm.MergedConfig = &Config{}
+
+	irccon := &ircevent.Connection{Nick: "gowon", User: "gowon"}
+	n := &Network{Name: defaultNetwork, irccon: irccon, cm: cm, chm: NewChannelManager(irccon)}
+
+	r := gin.New()
+	r.POST("/channels/:name/join", createChannelHandler(Networks{n}, kindJoin, (*Network).Join, "key"))
+
+	cases := map[string]struct {
+		target   string
+		body     string
+		expected int
+		key      string
+	}{
+		"no body": {
+			target:   "/channels/nokey/join",
+			expected: http.StatusOK,
+		},
+		"key in body": {
+			target:   "/channels/keyed/join",
+			body:     `{"key": "secret"}`,
+			expected: http.StatusOK,
+			key:      "secret",
+		},
+		"key in query is ignored": {
+			target:   "/channels/query/join?key=secret",
+			expected: http.StatusOK,
+		},
+		"invalid json": {
+			target:   "/channels/broken/join",
+			body:     `{"key": `,
+			expected: http.StatusBadRequest,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tc.target, strings.NewReader(tc.body))
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expected, w.Code)
+
+			channel := "#" + strings.Split(tc.target, "/")[2]
+			assert.Equal(t, tc.key, n.chm.key(channel))
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"text/template"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/gowon-irc/gowon/pkg/message"
+)
+
+const (
+	hookSecretHeader = "X-Gowon-Secret"
+
+	unknownHookErrMsg    = "no hook with this name"
+	invalidSecretErrMsg  = "missing or invalid hook secret"
+	invalidPayloadErrMsg = "payload is not valid json"
+)
+
+type Hook struct {
+	Name     string `validate:"required,alphanum"`
+	Network  string
+	Dest     string `validate:"required,irc_channel|irc_nick"`
+	Secret   string
+	Template string `validate:"required,go_template"`
+	// Raw leaves formatting tokens in payload values, so the sender can
+	// colour the message.
+	Raw bool
+}
+
+var hookFuncs = template.FuncMap{
+	"escape": message.EscapeTokens,
+	"join":   strings.Join,
+	"upper":  strings.ToUpper,
+	"lower":  strings.ToLower,
+}
+
+func parseHookTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Funcs(hookFuncs).Option("missingkey=error").Parse(text)
+}
+
+func validateGoTemplate(field validator.FieldLevel) bool {
+	_, err := parseHookTemplate("", field.Field().String())
+	return err == nil
+}
+
+func findHook(hooks []Hook, name string) (Hook, bool) {
+	for _, h := range hooks {
+		if h.Name == name {
+			return h, true
+		}
+	}
+
+	return Hook{}, false
+}
+
+func escapeValues(data interface{}) interface{} {
+	switch v := data.(type) {
+	case string:
+		return message.EscapeTokens(v)
+	case []interface{}:
+		for i := range v {
+			v[i] = escapeValues(v[i])
+		}
+	case map[string]interface{}:
+		for k := range v {
+			v[k] = escapeValues(v[k])
+		}
+	}
+
+	return data
+}
+
+func (h *Hook) Render(payload []byte) (string, error) {
+	var data interface{}
+	if err := json.Unmarshal(payload, &data); err != nil {
+		return "", err
+	}
+
+	if !h.Raw {
+		data = escapeValues(data)
+	}
+
+	tmpl, err := parseHookTemplate(h.Name, h.Template)
+	if err != nil {
+		return "", err
+	}
+
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(out.String()), nil
+}
+
+// hookMessage builds the body of a message as it would be posted to
+// /message, so hooks go through the same checks and send path.
+func (h *Hook) hookMessage(text string) ([]byte, error) {
+	return json.Marshal(message.Message{
+		Module:  h.Name,
+		Msg:     text,
+		Dest:    h.Dest,
+		Network: h.Network,
+	})
+}
+
+func createHookHandler(cm *ConfigManager, networks Networks) func(*gin.Context) {
+	return func(c *gin.Context) {
+		name := c.Param("name")
+
+		h, ok := findHook(cm.Config().Hooks, name)
+		if !ok {
+			c.IndentedJSON(http.StatusNotFound, gin.H{"hook": name, "error": unknownHookErrMsg})
+			return
+		}
+
+		if h.Secret != "" && subtle.ConstantTimeCompare([]byte(h.Secret), []byte(c.GetHeader(hookSecretHeader))) != 1 {
+			c.IndentedJSON(http.StatusUnauthorized, gin.H{"hook": name, "error": invalidSecretErrMsg})
+			return
+		}
+
+		payload, err := c.GetRawData()
+		if err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"hook": name, "error": err.Error()})
+			return
+		}
+
+		if !json.Valid(payload) {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"hook": name, "error": invalidPayloadErrMsg})
+			return
+		}
+
+		text, err := h.Render(payload)
+		if err != nil {
+			c.IndentedJSON(http.StatusUnprocessableEntity, gin.H{"hook": name, "error": err.Error()})
+			return
+		}
+
+		body, err := h.hookMessage(text)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"hook": name, "error": err.Error()})
+			return
+		}
+
+		m, status, errBody := deliverMessage(networks, nil, body)
+		if errBody != nil {
+			errBody["hook"] = name
+			c.IndentedJSON(status, errBody)
+			return
+		}
+
+		c.IndentedJSON(status, m)
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ergochat/irc-go/ircevent"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHookRender(t *testing.T) {
+	cases := map[string]struct {
+		template string
+		payload  string
+		raw      bool
+		expected string
+		err      bool
+	}{
+		"fields": {
+			template: "{green}{{.build.status}}{clear}: {{.build.name}}",
+			payload:  `{"build": {"status": "passed", "name": "gowon"}}`,
+			expected: "{green}passed{clear}: gowon",
+		},
+		"range": {
+			template: "{{range .alerts}}{red}{{.name}}{clear}\n{{end}}",
+			payload:  `{"alerts": [{"name": "disk"}, {"name": "cpu"}]}`,
+			expected: "{red}disk{clear}\n{red}cpu{clear}",
+		},
+		"escaped by default": {
+			template: "{red}{{.msg}}",
+			payload:  `{"msg": "{red}not a colour"}`,
+			expected: "{red}{{red}not a colour",
+		},
+		"escaped in lists": {
+			template: "{{range .list}}{{.}}{{end}}",
+			payload:  `{"list": ["{blue}", {"nested": "x"}]}`,
+			expected: "{{blue}map[nested:x]",
+		},
+		"raw": {
+			template: "{{.msg}}",
+			payload:  `{"msg": "{red}a colour"}`,
+			raw:      true,
+			expected: "{red}a colour",
+		},
+		"escape raw": {
+			template: "{{escape .msg}}",
+			payload:  `{"msg": "{red}not a colour"}`,
+			raw:      true,
+			expected: "{{red}not a colour",
+		},
+		"upper": {
+			template: "{{upper .state}}",
+			payload:  `{"state": "firing"}`,
+			expected: "FIRING",
+		},
+		"invalid json": {
+			template: "{{.msg}}",
+			payload:  `{"msg": `,
+			err:      true,
+		},
+		"missing field": {
+			template: "{{.status}}",
+			payload:  `{}`,
+			err:      true,
+		},
+		"optional field": {
+			template: `{{with index . "status"}}{{.}}{{else}}unknown{{end}}`,
+			payload:  `{}`,
+			expected: "unknown",
+		},
+		"execution error": {
+			template: "{{index .list 5}}",
+			payload:  `{"list": [1]}`,
+			err:      true,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			h := Hook{Name: "test", Template: tc.template, Raw: tc.raw}
+
+			out, err := h.Render([]byte(tc.payload))
+			if tc.err {
+				assert.NotNil(t, err)
+				return
+			}
+
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, out)
+		})
+	}
+}
+
+func TestCreateHookHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cm := NewConfigManager()
+	cm.MergedConfig = &Config{
+		Hooks: []Hook{
+			{Name: "ci", Dest: "#gowon", Template: "{{.status}}"},
+			{Name: "alerts", Dest: "#gowon", Secret: "hunter2", Template: "{{.alert}}"},
+			{Name: "elsewhere", Dest: "#other", Template: "{{.status}}"},
+		},
+	}
+
+	irccon := &ircevent.Connection{Nick: "gowon", User: "gowon"}
+	n := &Network{Name: defaultNetwork, irccon: irccon, cm: cm, chm: NewChannelManager(irccon)}
+	n.chm.setPresent("#gowon", true)
+
+	r := gin.New()
+	r.POST("/hooks/:name", createHookHandler(cm, Networks{n}))
+
+	cases := map[string]struct {
+		path     string
+		secret   string
+		body     string
+		expected int
+		errMsg   string
+	}{
+		"rendered": {
+			path:     "/hooks/ci",
+			body:     `{"status": "passed"}`,
+			expected: http.StatusCreated,
+		},
+		"unknown hook": {
+			path:     "/hooks/cd",
+			body:     `{"status": "passed"}`,
+			expected: http.StatusNotFound,
+			errMsg:   unknownHookErrMsg,
+		},
+		"secret header": {
+			path:     "/hooks/alerts",
+			secret:   "hunter2",
+			body:     `{"alert": "disk full"}`,
+			expected: http.StatusCreated,
+		},
+		"secret query": {
+			path:     "/hooks/alerts?secret=hunter2",
+			body:     `{"alert": "disk full"}`,
+			expected: http.StatusUnauthorized,
+			errMsg:   invalidSecretErrMsg,
+		},
+		"missing secret": {
+			path:     "/hooks/alerts",
+			body:     `{"alert": "disk full"}`,
+			expected: http.StatusUnauthorized,
+			errMsg:   invalidSecretErrMsg,
+		},
+		"wrong secret": {
+			path:     "/hooks/alerts",
+			secret:   "hunter3",
+			body:     `{"alert": "disk full"}`,
+			expected: http.StatusUnauthorized,
+			errMsg:   invalidSecretErrMsg,
+		},
+		"invalid json": {
+			path:     "/hooks/ci",
+			body:     `{"status": `,
+			expected: http.StatusBadRequest,
+			errMsg:   invalidPayloadErrMsg,
+		},
+		"empty render": {
+			path:     "/hooks/ci",
+			body:     `{"status": ""}`,
+			expected: http.StatusUnprocessableEntity,
+		},
+		"missing field": {
+			path:     "/hooks/ci",
+			body:     `{}`,
+			expected: http.StatusUnprocessableEntity,
+		},
+		"not joined": {
+			path:     "/hooks/elsewhere",
+			body:     `{"status": "passed"}`,
+			expected: http.StatusForbidden,
+			errMsg:   notJoinedErrMsg,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tc.path, strings.NewReader(tc.body))
+			if tc.secret != "" {
+				req.Header.Set(hookSecretHeader, tc.secret)
+			}
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expected, w.Code)
+			if tc.errMsg != "" {
+				assert.Contains(t, w.Body.String(), tc.errMsg)
+			}
+		})
+	}
+}
+
+func TestValidateGoTemplate(t *testing.T) {
+	v := validator.New()
+	assert.Nil(t, v.RegisterValidation("go_template", validateGoTemplate))
+
+	assert.Nil(t, v.Var("{green}{{.status}}{clear}", "go_template"))
+	assert.Nil(t, v.Var("{{escape .msg}}", "go_template"))
+	assert.NotNil(t, v.Var("{{.status", "go_template"))
+	assert.NotNil(t, v.Var("{{unknown .status}}", "go_template"))
+}
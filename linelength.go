@@ -0,0 +1,146 @@
+package main
+
+import (
+	"log"
+	"strings"
+
+	"github.com/ergochat/irc-go/ircevent"
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+const (
+	defaultMaxLineLength = 512
+	minLineBudget        = 32
+
+	// Used in place of the real host until the server has told us what it is.
+	maxHostLength = 63
+)
+
+func prefixLength(nick, userhost, user string) int {
+	if userhost != "" {
+		return len(nick) + 1 + len(userhost)
+	}
+
+	return len(nick) + len("!~") + len(user) + 1 + maxHostLength
+}
+
+func lineBudget(limit, prefix int, dest string) int {
+	if limit <= 0 {
+		limit = defaultMaxLineLength
+	}
+
+	budget := limit - prefix - len(": PRIVMSG  :\r\n") - len(dest)
+	if budget < minLineBudget {
+		return minLineBudget
+	}
+
+	return budget
+}
+
+func tagsLength(tags map[string]string) (out int) {
+	for k, v := range tags {
+		if out > 0 {
+			out++
+		}
+
+		out += len(k)
+		if v != "" {
+			out += 1 + len(ircmsg.EscapeTagValue(v))
+		}
+	}
+
+	return out
+}
+
+func userhost(source string) string {
+	nuh, err := ircmsg.ParseNUH(source)
+	if err != nil || nuh.User == "" || nuh.Host == "" {
+		return ""
+	}
+
+	return nuh.User + "@" + nuh.Host
+}
+
+func (n *Network) setUserhost(uh string) {
+	if uh == "" {
+		return
+	}
+
+	n.mu.Lock()
+	n.userhost = uh
+	n.mu.Unlock()
+}
+
+func (n *Network) lineBudget(dest string) int {
+	n.mu.Lock()
+	uh := n.userhost
+	n.mu.Unlock()
+
+	nick := n.irccon.CurrentNick()
+	if nick == "" {
+		nick = n.irccon.Nick
+	}
+
+	// the connection's limit is fixed when it is created, so a larger
+	// value picked up on reload can't be used until a restart
+	limit := n.cm.Config().MaxLineLength
+	if limit <= 0 || limit > n.irccon.MaxLineLen {
+		limit = n.irccon.MaxLineLen
+	}
+
+	return lineBudget(limit, prefixLength(nick, uh, n.irccon.User), dest)
+}
+
+func (n *Network) trackUserhost(irccon *ircevent.Connection) {
+	irccon.AddCallback(ircevent.RPL_WELCOME, func(e ircmsg.Message) {
+		if len(e.Params) == 0 {
+			return
+		}
+
+		words := strings.Fields(e.Params[len(e.Params)-1])
+		if len(words) > 0 {
+			n.setUserhost(userhost(words[len(words)-1]))
+		}
+	})
+
+	irccon.AddCallback("JOIN", func(e ircmsg.Message) {
+		if e.Nick() == irccon.CurrentNick() {
+			n.setUserhost(userhost(e.Source))
+		}
+	})
+
+	irccon.AddCallback("CHGHOST", func(e ircmsg.Message) {
+		if e.Nick() == irccon.CurrentNick() && len(e.Params) >= 2 {
+			n.setUserhost(e.Params[0] + "@" + e.Params[1])
+		}
+	})
+
+	irccon.AddCallback("396", func(e ircmsg.Message) {
+		if len(e.Params) < 2 {
+			return
+		}
+
+		n.mu.Lock()
+		user, _, ok := strings.Cut(n.userhost, "@")
+		n.mu.Unlock()
+
+		if ok {
+			n.setUserhost(user + "@" + e.Params[1])
+		}
+	})
+
+	irccon.AddDisconnectCallback(func(e ircmsg.Message) {
+		n.mu.Lock()
+		n.userhost = ""
+		n.mu.Unlock()
+	})
+}
+
+func dropLongTags(tags map[string]string) map[string]string {
+	if tagsLength(tags) > ircmsg.MaxlenClientTagData {
+		log.Printf("Dropping client tags longer than %d bytes", ircmsg.MaxlenClientTagData)
+		return nil
+	}
+
+	return tags
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ergochat/irc-go/ircmsg"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrefixLength(t *testing.T) {
+	cases := map[string]struct {
+		nick     string
+		userhost string
+		user     string
+		expected int
+	}{
+		"known hostmask": {
+			nick:     "gowon",
+			userhost: "~gowon@example.com",
+			user:     "gowon",
+			expected: len("gowon!~gowon@example.com"),
+		},
+		"unknown hostmask": {
+			nick:     "gowon",
+			userhost: "",
+			user:     "gowon",
+			expected: len("gowon!~gowon@") + maxHostLength,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, prefixLength(tc.nick, tc.userhost, tc.user))
+		})
+	}
+}
+
+func TestLineBudget(t *testing.T) {
+	source := "gowon!~gowon@example.com"
+
+	cases := map[string]struct {
+		limit    int
+		prefix   int
+		dest     string
+		expected int
+	}{
+		"fills the line": {
+			limit:    512,
+			prefix:   len(source),
+			dest:     "#gowon",
+			expected: 512 - len(":"+source+" PRIVMSG #gowon :\r\n"),
+		},
+		"default limit": {
+			limit:    0,
+			prefix:   len(source),
+			dest:     "#gowon",
+			expected: 512 - len(":"+source+" PRIVMSG #gowon :\r\n"),
+		},
+		"larger limit": {
+			limit:    1024,
+			prefix:   len(source),
+			dest:     "#gowon",
+			expected: 1024 - len(":"+source+" PRIVMSG #gowon :\r\n"),
+		},
+		"minimum budget": {
+			limit:    128,
+			prefix:   100,
+			dest:     "#" + strings.Repeat("a", 50),
+			expected: minLineBudget,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, lineBudget(tc.limit, tc.prefix, tc.dest))
+		})
+	}
+}
+
+func TestTagsLength(t *testing.T) {
+	cases := map[string]struct {
+		tags     map[string]string
+		expected int
+	}{
+		"no tags": {
+			tags:     nil,
+			expected: 0,
+		},
+		"single tag": {
+			tags:     map[string]string{"+draft/reply": "abc"},
+			expected: len("+draft/reply=abc"),
+		},
+		"tag without value": {
+			tags:     map[string]string{"+draft/typing": ""},
+			expected: len("+draft/typing"),
+		},
+		"escaped value": {
+			tags:     map[string]string{"+a": "b c"},
+			expected: len(`+a=b\sc`),
+		},
+		"multiple tags": {
+			tags:     map[string]string{"+a": "b", "+c": "d"},
+			expected: len("+a=b;+c=d"),
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tagsLength(tc.tags))
+		})
+	}
+}
+
+func TestUserhost(t *testing.T) {
+	cases := map[string]struct {
+		source   string
+		expected string
+	}{
+		"full hostmask": {
+			source:   "gowon!~gowon@example.com",
+			expected: "~gowon@example.com",
+		},
+		"nick only": {
+			source:   "gowon",
+			expected: "",
+		},
+		"server": {
+			source:   "irc.example.com",
+			expected: "",
+		},
+		"empty": {
+			source:   "",
+			expected: "",
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, userhost(tc.source))
+		})
+	}
+}
+
+func TestDropLongTags(t *testing.T) {
+	short := map[string]string{"+a": "b"}
+	assert.Equal(t, short, dropLongTags(short))
+
+	long := map[string]string{"+a": strings.Repeat("b", 5000)}
+	assert.Nil(t, dropLongTags(long))
+}
+
+func TestSendMessageAtMaxLineLength(t *testing.T) {
+	s := newTestIrcServer(t, "")
+	cfg := &Config{MaxLineLength: 1024}
+	n := newTestNetwork(t, s, cfg)
+	connectTestNetwork(t, n)
+
+	// the budget is only this large once the welcome has told us our host
+	budget := 1024 - len("gowon!gowon@test.host") - len(": PRIVMSG  :\r\n") - len("#gowon")
+	assert.Eventually(t, func() bool {
+		return n.lineBudget("#gowon") == budget
+	}, 5*time.Second, 10*time.Millisecond)
+
+	msg := strings.Repeat("a", budget)
+	n.SendMessage("#gowon", msg, nil)
+
+	m, err := ircmsg.ParseLine(s.expect(t, "PRIVMSG"))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"#gowon", msg}, m.Params)
+
+	// raising the limit on reload can't go past what the connection allows
+	cfg.MaxLineLength = 2048
+	assert.Equal(t, budget, n.lineBudget("#gowon"))
+}
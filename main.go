@@ -1,57 +1,129 @@
 package main
 
 import (
-	"crypto/tls"
 	"fmt"
 	"log"
+	"net"
 	"os"
-	"strings"
-	"time"
+	"strconv"
+	"sync"
 
-	"github.com/flowchartsman/retry"
 	"github.com/fsnotify/fsnotify"
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
 	"github.com/jessevdk/go-flags"
-
-	"github.com/ergochat/irc-go/ircevent"
-	"github.com/ergochat/irc-go/ircmsg"
 )
 
 var validate *validator.Validate
 
-func setupRouter(cm *ConfigManager, cr *CommandRouter, configDir string) error {
+func loadConfig(cm *ConfigManager, configDir string) error {
+	validate = validator.New(validator.WithRequiredStructEnabled())
+
+	if err := validate.RegisterValidation("irc_channel", validateIrcChannel); err != nil {
+		return err
+	}
+
+	if err := validate.RegisterValidation("irc_nick", validateIrcNick); err != nil {
+		return err
+	}
+
+	if err := validate.RegisterValidation("message_filter", validateMessageFilter); err != nil {
+		return err
+	}
+
+	if err := validate.RegisterValidation("mqtt_topic", validateMqttTopic); err != nil {
+		return err
+	}
+
+	if err := validate.RegisterValidation("endpoint_url", validateEndpointURL); err != nil {
+		return err
+	}
+
+	if err := validate.RegisterValidation("grpc_target", validateGrpcTarget); err != nil {
+		return err
+	}
+
+	if err := validate.RegisterValidation("go_template", validateGoTemplate); err != nil {
+		return err
+	}
+
 	if err := cm.LoadDirectory(configDir); err != nil {
 		return err
 	}
 
-	err := cm.Merge()
+	merged, err := cm.merge()
 	if err != nil {
 		return err
 	}
 
-	cfg := cm.MergedConfig
+	if err := cm.LoadInvites(merged.InvitesFile, configDir); err != nil {
+		return err
+	}
 
-	validate = validator.New(validator.WithRequiredStructEnabled())
+	// the config is only published once it is valid, so nothing reads a
+	// bad one while a reload is rejected
+	merged, err = cm.merge()
+	if err != nil {
+		return err
+	}
 
-	if err := validate.RegisterValidation("irc_channel", validateIrcChannel); err != nil {
+	if err := validate.Struct(merged); err != nil {
 		return err
 	}
 
-	if err := validate.Struct(cfg); err != nil {
+	if err := checkNetworks(merged); err != nil {
 		return err
 	}
 
-	cr.Clear()
+	cm.SetConfig(merged)
+
+	return nil
+}
+
+func setupRouter(cr *CommandRouter, cfg *Config, networks Networks, modules *ModuleHub, mt *MqttTransport, grpcModules *GrpcHub) {
+	// the new commands are built aside and swapped in at once, so messages
+	// routed during a reload see either the old or the new list
+	next := &CommandRouter{}
 
 	for _, c := range cfg.Commands {
-		cr.Add(&c)
+		switch c.Type {
+		case commandTypeGrpc:
+		case commandTypeMqtt:
+			if mt == nil {
+				log.Printf("Skipping command %s, it uses mqtt but no broker is configured", c.Command)
+				continue
+			}
+
+			next.AddRouterCommand(mt.Command(&c))
+		default:
+			next.Add(&c)
+		}
+	}
+	for _, rc := range grpcModules.Commands(cfg.Commands) {
+		next.AddRouterCommand(rc)
 	}
-	cr.AddInternal("h", "list and describe commands", createHelpCommandFunc(cr))
-	cr.AddInternal("gowon", "list and describe commands", createHelpCommandFunc(cr))
-	cr.SortPriority()
+	for _, rc := range modules.Commands() {
+		next.AddRouterCommand(rc)
+	}
+	next.AddInternal("h", "list and describe commands", createHelpCommandFunc(cr))
+	next.AddInternal("gowon", "list and describe commands", createHelpCommandFunc(cr))
+	next.AddInternal("join", "join a channel (admin only)", createChannelCommandFunc(cfg.Admins, "join", networks, (*Network).Join))
+	next.AddInternal("part", "part a channel (admin only)", createChannelCommandFunc(cfg.Admins, "part", networks, (*Network).Part))
+	next.SortPriority()
 
-	return nil
+	cr.Replace(next.Commands)
+}
+
+func runHttp(r *gin.Engine, cfg *Config) error {
+	if cfg.HttpSocket != "" {
+		if err := os.Remove(cfg.HttpSocket); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		return r.RunUnix(cfg.HttpSocket)
+	}
+
+	return r.Run(net.JoinHostPort(cfg.HttpAddr, strconv.Itoa(cfg.HttpPort)))
 }
 
 func main() {
@@ -69,12 +141,66 @@ func main() {
 
 	cm.AddOpts(opts)
 
-	if err := setupRouter(cm, cr, opts.ConfigDir); err != nil {
+	if err := loadConfig(cm, opts.ConfigDir); err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
 
-	cfg := cm.MergedConfig
+	cfg := cm.Config()
+
+	ps, err := NewPasteStore(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	networks := Networks{}
+	for _, ncfg := range cfg.AllNetworks() {
+		n, err := NewNetwork(ncfg, cm, cr, ps)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		networks = append(networks, n)
+	}
+
+	modules := NewModuleHub()
+
+	var mt *MqttTransport
+	if cfg.Broker != "" {
+		mt, err = NewMqttTransport(cfg, networks)
+		if err != nil {
+			log.Println(err)
+		}
+		defer mt.Close()
+	}
+
+	grpcModules := NewGrpcHub()
+	defer grpcModules.Close()
+
+	var routerMu sync.Mutex
+	reloadRouter := func() {
+		routerMu.Lock()
+		defer routerMu.Unlock()
+
+		setupRouter(cr, cm.Config(), networks, modules, mt, grpcModules)
+	}
+
+	reloadRouter()
+	modules.OnChange(reloadRouter)
+	grpcModules.OnChange(reloadRouter)
+	go grpcModules.Refresh(cm.Config().Commands)
+
+	relayer := NewRelayer(cm, networks)
+	hub := NewEventHub()
+	for _, n := range networks {
+		n.irccon.AddCallback("PRIVMSG", createRelayHandler(n, relayer))
+		hub.Watch(n)
+		grpcModules.Watch(n)
+
+		if mt != nil {
+			mt.Watch(n)
+		}
+	}
 
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
@@ -92,9 +218,16 @@ func main() {
 				// if !event.Has(fsnotify.Chmod) {
 				log.Printf("Config file %s has changed, reloading command router", event.Name)
 
-				if err := setupRouter(cm, cr, opts.ConfigDir); err != nil {
+				old := cm.Config()
+
+				if err := loadConfig(cm, opts.ConfigDir); err != nil {
 					log.Println(err)
+					continue
 				}
+
+				reloadRouter()
+				go grpcModules.Refresh(cm.Config().Commands)
+				networks.Sync(old, cm.Config())
 				// }
 			case err, ok := <-watcher.Errors:
 				if !ok {
@@ -110,58 +243,36 @@ func main() {
 		log.Fatal(err)
 	}
 
-	irccon := ircevent.Connection{
-		Server:      cfg.Server,
-		Nick:        cfg.Nick,
-		User:        cfg.User,
-		Debug:       cfg.Debug,
-		RequestCaps: []string{"server-time"},
-	}
-	// ircevent.VerboseCallbackHandler = cfg.Verbose
-
-	irccon.UseTLS = cfg.UseTLS
-	if cfg.UseTLS {
-		irccon.TLSConfig = &tls.Config{
-			ServerName: strings.Split(cfg.Server, ":")[0],
-			MinVersion: tls.VersionTLS12,
-		}
+	if len(cfg.ApiTokens) == 0 && cfg.ApiNoAuth {
+		log.Println("No api tokens are configured, the http api does not require authentication")
+	} else if len(cfg.ApiTokens) == 0 {
+		log.Println("No api tokens are configured, the http api will refuse every request")
 	}
 
-	if cfg.Password != "" {
-		irccon.UseSASL = true
-		irccon.SASLLogin = cfg.Nick
-		irccon.SASLPassword = cfg.Password
-	}
-
-	irccon.AddConnectCallback(func(e ircmsg.Message) {
-		for _, channel := range cfg.Channels {
-			if err = irccon.Join(channel); err != nil {
-				log.Println(err)
-			}
-		}
-	})
+	httpRouter := gin.Default()
 
-	privMsgHandler := createIrcHandler(&irccon, cr)
-	irccon.AddCallback("PRIVMSG", privMsgHandler)
+	api := httpRouter.Group("/", createAuthMiddleware(cm))
+	api.POST("/message", createHttpHandler(networks))
+	api.POST("/channels/:name/join", createChannelHandler(networks, kindJoin, (*Network).Join, "key"))
+	api.POST("/channels/:name/part", createChannelHandler(networks, kindPart, (*Network).Part, "reason"))
+	api.GET("/status", createStatusHandler(networks))
+	api.GET("/events", createEventsHandler(hub))
+	api.GET("/modules", createModuleHandler(modules, networks))
 
-	httpRouter := gin.Default()
-	httpRouter.POST("/message", createHttpHandler(&irccon))
+	httpRouter.POST("/hooks/:name", createHookHandler(cm, networks))
+	httpRouter.POST("/forge", createForgeHandler(cm, networks))
 
-	retrier := retry.NewRetrier(5, 100*time.Millisecond, 5*time.Second)
-	err = retrier.Run(func() error {
-		return irccon.Connect()
-	})
-	if err != nil {
-		log.Fatal(err)
+	if lps, ok := ps.(*LocalPasteStore); ok {
+		httpRouter.GET("/pastes/:id", createPasteHandler(lps))
 	}
 
 	go func() {
-		if err := httpRouter.Run(fmt.Sprintf("0.0.0.0:%d", cfg.HttpPort)); err != nil {
+		if err := runHttp(httpRouter, cfg); err != nil {
 			log.Fatal(err)
 		}
 	}()
 
-	irccon.Loop()
+	networks.Run()
 
 	log.Println("shutdown complete")
 }
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/gowon-irc/gowon/pkg/message"
+)
+
+const markdownBullet = "•"
+
+var (
+	markdownInlineRegex  = regexp.MustCompile("`([^`]+)`|\\[([^\\]]+)\\]\\(([^)\\s]+)\\)|<(https?://[^>\\s]+)>|https?://\\S+")
+	markdownBoldRegex    = regexp.MustCompile(`\*\*(\S(?:.*?\S)?)\*\*|__(\S(?:.*?\S)?)__`)
+	markdownStrikeRegex  = regexp.MustCompile(`~~(\S(?:.*?\S)?)~~`)
+	markdownItalicRegex  = regexp.MustCompile(`\*(\S(?:.*?\S)?)\*`)
+	markdownUnderRegex   = regexp.MustCompile(`(^|\W)_(\S(?:.*?\S)?)_\b`)
+	markdownHeadingRegex = regexp.MustCompile(`^#{1,6}\s+(.*?)\s*#*$`)
+	markdownBulletRegex  = regexp.MustCompile(`^(\s*)[-*+]\s+(.*)$`)
+	markdownFenceRegex   = regexp.MustCompile("^\\s*(```|~~~)")
+)
+
+func markdownEmphasis(text string) string {
+	text = message.EscapeTokens(text)
+	text = markdownBoldRegex.ReplaceAllString(text, "{bold}$1$2{bold}")
+	text = markdownStrikeRegex.ReplaceAllString(text, "{strikethrough}$1{strikethrough}")
+	text = markdownItalicRegex.ReplaceAllString(text, "{italic}$1{italic}")
+	text = markdownUnderRegex.ReplaceAllString(text, "$1{italic}$2{italic}")
+
+	return text
+}
+
+func markdownInline(line string) string {
+	var sb strings.Builder
+
+	last := 0
+	for _, m := range markdownInlineRegex.FindAllStringSubmatchIndex(line, -1) {
+		sb.WriteString(markdownEmphasis(line[last:m[0]]))
+		last = m[1]
+
+		switch {
+		case m[2] >= 0:
+			sb.WriteString("{monospace}" + message.EscapeTokens(line[m[2]:m[3]]) + "{monospace}")
+		case m[4] >= 0:
+			text, url := line[m[4]:m[5]], line[m[6]:m[7]]
+			if text == url {
+				sb.WriteString(message.EscapeTokens(url))
+				continue
+			}
+
+			sb.WriteString(markdownEmphasis(text) + " (" + message.EscapeTokens(url) + ")")
+		case m[8] >= 0:
+			sb.WriteString(message.EscapeTokens(line[m[8]:m[9]]))
+		default:
+			sb.WriteString(message.EscapeTokens(line[m[0]:m[1]]))
+		}
+	}
+
+	sb.WriteString(markdownEmphasis(line[last:]))
+
+	return sb.String()
+}
+
+func markdownMsg(msg string) string {
+	out := []string{}
+	code := false
+
+	for _, line := range strings.Split(msg, "\n") {
+		if markdownFenceRegex.MatchString(line) {
+			code = !code
+			continue
+		}
+
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		if code {
+			out = append(out, "{monospace}"+message.EscapeTokens(line)+"{monospace}")
+			continue
+		}
+
+		if m := markdownHeadingRegex.FindStringSubmatch(line); m != nil {
+			out = append(out, "{bold}"+markdownInline(m[1])+"{bold}")
+			continue
+		}
+
+		if m := markdownBulletRegex.FindStringSubmatch(line); m != nil {
+			out = append(out, m[1]+markdownBullet+" "+markdownInline(m[2]))
+			continue
+		}
+
+		out = append(out, markdownInline(line))
+	}
+
+	return strings.Join(out, "\n")
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/gowon-irc/gowon/pkg/message"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarkdownMsg(t *testing.T) {
+	cases := map[string]struct {
+		in       string
+		expected string
+	}{
+		"plain text": {
+			in:       "hello world",
+			expected: "hello world",
+		},
+		"bold": {
+			in:       "**hello** __world__",
+			expected: "{bold}hello{bold} {bold}world{bold}",
+		},
+		"italic": {
+			in:       "*hello* _world_.",
+			expected: "{italic}hello{italic} {italic}world{italic}.",
+		},
+		"snake case": {
+			in:       "call some_function_name",
+			expected: "call some_function_name",
+		},
+		"multiplication": {
+			in:       "2 * 3 * 4",
+			expected: "2 * 3 * 4",
+		},
+		"strikethrough": {
+			in:       "~~gone~~",
+			expected: "{strikethrough}gone{strikethrough}",
+		},
+		"inline code": {
+			in:       "run `go test **./...**` now",
+			expected: "run {monospace}go test **./...**{monospace} now",
+		},
+		"link": {
+			in:       "see [the **docs**](https://example.com/a_b_c)",
+			expected: "see the {bold}docs{bold} (https://example.com/a_b_c)",
+		},
+		"link with url text": {
+			in:       "[https://example.com](https://example.com)",
+			expected: "https://example.com",
+		},
+		"bare urls": {
+			in:       "<https://example.com/_a_> https://example.com/*b*",
+			expected: "https://example.com/_a_ https://example.com/*b*",
+		},
+		"heading": {
+			in:       "## Summary ##",
+			expected: "{bold}Summary{bold}",
+		},
+		"bullet list": {
+			in:       "- one\n* **two**\n  + three",
+			expected: "• one\n• {bold}two{bold}\n  • three",
+		},
+		"code block": {
+			in:       "before\n```go\nfunc main() {\n\n}\n```\nafter",
+			expected: "before\n{monospace}func main() {{{monospace}\n{monospace}}{monospace}\nafter",
+		},
+		"blank lines": {
+			in:       "one\n\n\ntwo",
+			expected: "one\ntwo",
+		},
+		"braces escaped": {
+			in:       "{red} is not a colour",
+			expected: "{{red} is not a colour",
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, markdownMsg(tc.in))
+		})
+	}
+}
+
+func TestMessageText(t *testing.T) {
+	cases := map[string]struct {
+		m        message.Message
+		expected string
+	}{
+		"tokens": {
+			m:        message.Message{Msg: "{red}hi"},
+			expected: "{red}hi",
+		},
+		"plain": {
+			m:        message.Message{Msg: "{red}hi", Plain: true},
+			expected: "{{red}hi",
+		},
+		"markdown": {
+			m:        message.Message{Msg: "**{red}hi**", Format: message.FormatMarkdown},
+			expected: "{bold}{{red}hi{bold}",
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, messageText(&tc.m))
+		})
+	}
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/gowon-irc/gowon/pkg/message"
+)
+
+const (
+	frameRegister = "register"
+	frameMessage  = "message"
+	frameReply    = "reply"
+	frameError    = "error"
+
+	moduleTimeout   = 30 * time.Second
+	registerTimeout = 10 * time.Second
+
+	notRegisteredErrMsg  = "first frame must register the module's commands"
+	invalidCommandErrMsg = "invalid command"
+	unknownFrameErrMsg   = "unknown frame type"
+	moduleTimeoutErrMsg  = "module did not reply in time"
+	moduleGoneErrMsg     = "module disconnected"
+)
+
+var commandNameRegex = regexp.MustCompile(`^[a-zA-Z0-9]+$`)
+
+type moduleFrame struct {
+	Type     string          `json:"type"`
+	Id       string          `json:"id,omitempty"`
+	Commands []Command       `json:"commands,omitempty"`
+	Message  json.RawMessage `json:"message,omitempty"`
+	Status   int             `json:"status,omitempty"`
+	Error    string          `json:"error,omitempty"`
+}
+
+func checkModuleCommand(cmd Command) error {
+	if !commandNameRegex.MatchString(cmd.Command) {
+		return fmt.Errorf("%s: %q", invalidCommandErrMsg, cmd.Command)
+	}
+
+	if _, err := regexp.Compile(cmd.Regex); err != nil {
+		return fmt.Errorf("%s: %s", invalidCommandErrMsg, err)
+	}
+
+	return nil
+}
+
+type moduleConn struct {
+	conn     *websocket.Conn
+	token    *ApiToken
+	commands []Command
+
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	nextId  uint64
+	pending map[string]chan *message.Message
+	closed  bool
+}
+
+func newModuleConn(conn *websocket.Conn, token *ApiToken) *moduleConn {
+	return &moduleConn{
+		conn:    conn,
+		token:   token,
+		pending: make(map[string]chan *message.Message),
+	}
+}
+
+func (mc *moduleConn) write(f moduleFrame) error {
+	mc.writeMu.Lock()
+	defer mc.writeMu.Unlock()
+
+	return mc.conn.WriteJSON(f)
+}
+
+func (mc *moduleConn) writeError(id string, status int, err string) {
+	if werr := mc.write(moduleFrame{Type: frameError, Id: id, Status: status, Error: err}); werr != nil {
+		log.Println(werr)
+	}
+}
+
+func (mc *moduleConn) Request(in *message.Message) (*message.Message, error) {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return nil, err
+	}
+
+	mc.mu.Lock()
+	if mc.closed {
+		mc.mu.Unlock()
+		return nil, errors.New(moduleGoneErrMsg)
+	}
+
+	mc.nextId++
+	id := strconv.FormatUint(mc.nextId, 10)
+	reply := make(chan *message.Message, 1)
+	mc.pending[id] = reply
+	mc.mu.Unlock()
+
+	defer func() {
+		mc.mu.Lock()
+		delete(mc.pending, id)
+		mc.mu.Unlock()
+	}()
+
+	if err := mc.write(moduleFrame{Type: frameMessage, Id: id, Message: body}); err != nil {
+		return nil, err
+	}
+
+	select {
+	case m, ok := <-reply:
+		if !ok {
+			return nil, errors.New(moduleGoneErrMsg)
+		}
+		return m, nil
+	case <-time.After(moduleTimeout):
+		return nil, errors.New(moduleTimeoutErrMsg)
+	}
+}
+
+func (mc *moduleConn) resolve(id string, m *message.Message) bool {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	reply, ok := mc.pending[id]
+	if !ok {
+		return false
+	}
+
+	select {
+	case reply <- m:
+		return true
+	default:
+		return false
+	}
+}
+
+func (mc *moduleConn) close() {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	mc.closed = true
+	for id, reply := range mc.pending {
+		close(reply)
+		delete(mc.pending, id)
+	}
+}
+
+type WebsocketCommand struct {
+	HttpCommand
+	conn *moduleConn
+}
+
+func (wc *WebsocketCommand) Send(in *message.Message) *message.Message {
+	out, err := wc.conn.Request(in)
+	if err != nil {
+		log.Printf("Command %s failed: %s", in.Command, err)
+		in.Msg = fmt.Sprintf("{red}Error: request to %s failed{clear}", in.Command)
+		return in
+	}
+
+	if out.Msg == "" {
+		return nil
+	}
+
+	// replies are sent on the network the request came from
+	if t := wc.conn.token; t != nil && !t.Allowed(messageKind(out.Msg), in.Network, out.Dest) {
+		log.Printf("Command %s replied to %s on %s, which its token does not allow", in.Command, out.Dest, in.Network)
+		return nil
+	}
+
+	return out
+}
+
+// Allows keeps messages the module's token isn't scoped to from reaching it.
+func (wc *WebsocketCommand) Allows(in *message.Message) bool {
+	return wc.conn.token == nil || wc.conn.token.Allowed(kindModule, in.Network, in.Dest)
+}
+
+func (wc *WebsocketCommand) GetHelp() string {
+	if wc.Help != "" {
+		return fmt.Sprintf("{cyan}%s{clear}: %s", wc.Command, wc.Help)
+	}
+
+	return fmt.Sprintf("{cyan}%s{clear}: no help found", wc.Command)
+}
+
+type ModuleHub struct {
+	mu       sync.Mutex
+	modules  map[*moduleConn]bool
+	onChange func()
+}
+
+func NewModuleHub() *ModuleHub {
+	return &ModuleHub{
+		modules: make(map[*moduleConn]bool),
+	}
+}
+
+func (h *ModuleHub) OnChange(f func()) {
+	h.mu.Lock()
+	h.onChange = f
+	h.mu.Unlock()
+}
+
+func (h *ModuleHub) changed() {
+	h.mu.Lock()
+	f := h.onChange
+	h.mu.Unlock()
+
+	if f != nil {
+		f()
+	}
+}
+
+func (h *ModuleHub) add(mc *moduleConn) {
+	h.mu.Lock()
+	h.modules[mc] = true
+	h.mu.Unlock()
+
+	h.changed()
+}
+
+func (h *ModuleHub) remove(mc *moduleConn) {
+	h.mu.Lock()
+	delete(h.modules, mc)
+	h.mu.Unlock()
+
+	mc.close()
+	h.changed()
+}
+
+func (h *ModuleHub) Commands() []RouterCommand {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	out := []RouterCommand{}
+
+	for mc := range h.modules {
+		for _, c := range mc.commands {
+			out = append(out, &WebsocketCommand{
+				HttpCommand: HttpCommand{
+					Command:  c.Command,
+					Regex:    c.Regex,
+					Help:     c.Help,
+					Priority: c.Priority,
+				},
+				conn: mc,
+			})
+		}
+	}
+
+	return out
+}
+
+func register(mc *moduleConn, timeout time.Duration) error {
+	if err := mc.conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+
+	var f moduleFrame
+	if err := mc.conn.ReadJSON(&f); err != nil {
+		return err
+	}
+
+	if err := mc.conn.SetReadDeadline(time.Time{}); err != nil {
+		return err
+	}
+
+	if f.Type != frameRegister {
+		return errors.New(notRegisteredErrMsg)
+	}
+
+	for _, c := range f.Commands {
+		if err := checkModuleCommand(c); err != nil {
+			return err
+		}
+	}
+
+	mc.commands = f.Commands
+
+	return nil
+}
+
+func (h *ModuleHub) serve(mc *moduleConn, networks Networks) {
+	for {
+		var f moduleFrame
+		if err := mc.conn.ReadJSON(&f); err != nil {
+			return
+		}
+
+		switch f.Type {
+		case frameReply:
+			var m message.Message
+			if err := json.Unmarshal(f.Message, &m); err != nil {
+				mc.writeError(f.Id, http.StatusBadRequest, err.Error())
+				continue
+			}
+
+			if !mc.resolve(f.Id, &m) {
+				log.Printf("Module replied to unknown or expired request %s", f.Id)
+			}
+		case frameMessage:
+			if _, status, errBody := deliverMessage(networks, mc.token, f.Message); errBody != nil {
+				mc.writeError(f.Id, status, fmt.Sprint(errBody["error"]))
+			}
+		default:
+			mc.writeError(f.Id, http.StatusBadRequest, unknownFrameErrMsg)
+		}
+	}
+}
+
+func createModuleHandler(h *ModuleHub, networks Networks) func(*gin.Context) {
+	return func(c *gin.Context) {
+		if !authorisedKind(c, kindModule) {
+			return
+		}
+
+		conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		defer conn.Close()
+
+		mc := newModuleConn(conn, contextToken(c))
+
+		if err := register(mc, registerTimeout); err != nil {
+			mc.writeError("", http.StatusBadRequest, err.Error())
+			return
+		}
+
+		log.Printf("Module connected with %d commands", len(mc.commands))
+
+		h.add(mc)
+		defer h.remove(mc)
+
+		h.serve(mc, networks)
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ergochat/irc-go/ircevent"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/gowon-irc/gowon/pkg/message"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckModuleCommand(t *testing.T) {
+	cases := map[string]struct {
+		cmd Command
+		err bool
+	}{
+		"valid": {
+			cmd: Command{Command: "weather", Regex: `^w(eather)?\b`},
+		},
+		"invalid name": {
+			cmd: Command{Command: "not valid"},
+			err: true,
+		},
+		"invalid regex": {
+			cmd: Command{Command: "weather", Regex: `(`},
+			err: true,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			err := checkModuleCommand(tc.cmd)
+			assert.Equal(t, tc.err, err != nil)
+		})
+	}
+}
+
+func dialModule(t *testing.T, h *ModuleHub) (*websocket.Conn, func()) {
+	gin.SetMode(gin.TestMode)
+
+	cm := NewConfigManager()
+	cm.MergedConfig = &Config{}
+
+	irccon := &ircevent.Connection{Nick: "gowon", User: "gowon"}
+	n := &Network{Name: defaultNetwork, irccon: irccon, cm: cm, chm: NewChannelManager(irccon)}
+	n.chm.setPresent("#gowon", true)
+
+	r := gin.New()
+	r.GET("/modules", createModuleHandler(h, Networks{n}))
+	srv := httptest.NewServer(r)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/modules", nil)
+	assert.Nil(t, err)
+
+	return conn, func() {
+		conn.Close()
+		srv.Close()
+	}
+}
+
+func TestModuleTransport(t *testing.T) {
+	h := NewModuleHub()
+
+	changes := make(chan int, 10)
+	h.OnChange(func() { changes <- len(h.Commands()) })
+
+	conn, done := dialModule(t, h)
+	defer done()
+
+	assert.Nil(t, conn.WriteJSON(moduleFrame{Type: frameRegister, Commands: []Command{{Command: "echo", Help: "echo things"}}}))
+	assert.Equal(t, 1, <-changes)
+
+	cmds := h.Commands()
+	assert.Equal(t, "echo", cmds[0].GetCommand())
+	assert.True(t, cmds[0].Match(".echo hi"))
+	assert.Equal(t, "{cyan}echo{clear}: echo things", cmds[0].GetHelp())
+
+	replies := make(chan *message.Message)
+	go func() {
+		replies <- cmds[0].Send(&message.Message{Msg: ".echo hi", Dest: "#gowon", Command: "echo", Args: "hi"})
+	}()
+
+	var f moduleFrame
+	assert.Nil(t, conn.ReadJSON(&f))
+	assert.Equal(t, frameMessage, f.Type)
+
+	var in message.Message
+	assert.Nil(t, json.Unmarshal(f.Message, &in))
+	assert.Equal(t, "hi", in.Args)
+
+	out, _ := json.Marshal(message.Message{Module: "echo", Msg: in.Args, Dest: in.Dest})
+	assert.Nil(t, conn.WriteJSON(moduleFrame{Type: frameReply, Id: f.Id, Message: out}))
+
+	reply := <-replies
+	assert.Equal(t, "hi", reply.Msg)
+	assert.Equal(t, "#gowon", reply.Dest)
+
+	unsolicited, _ := json.Marshal(message.Message{Module: "echo", Msg: "hello", Dest: "#elsewhere"})
+	assert.Nil(t, conn.WriteJSON(moduleFrame{Type: frameMessage, Id: "a", Message: unsolicited}))
+
+	assert.Nil(t, conn.ReadJSON(&f))
+	assert.Equal(t, frameError, f.Type)
+	assert.Equal(t, "a", f.Id)
+	assert.Equal(t, http.StatusForbidden, f.Status)
+	assert.Equal(t, notJoinedErrMsg, f.Error)
+
+	conn.Close()
+
+	select {
+	case n := <-changes:
+		assert.Equal(t, 0, n)
+	case <-time.After(time.Second):
+		t.Fatal("module was not removed after disconnecting")
+	}
+}
+
+func TestModuleTransportRegisterFirst(t *testing.T) {
+	conn, done := dialModule(t, NewModuleHub())
+	defer done()
+
+	assert.Nil(t, conn.WriteJSON(moduleFrame{Type: frameReply}))
+
+	var f moduleFrame
+	assert.Nil(t, conn.ReadJSON(&f))
+	assert.Equal(t, frameError, f.Type)
+	assert.Equal(t, notRegisteredErrMsg, f.Error)
+}
+
+func TestRegisterTimeout(t *testing.T) {
+	errs := make(chan error, 1)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			errs <- err
+			return
+		}
+		defer conn.Close()
+
+		errs <- register(newModuleConn(conn, nil), 50*time.Millisecond)
+	}))
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	select {
+	case err := <-errs:
+		assert.ErrorContains(t, err, "timeout")
+	case <-time.After(5 * time.Second):
+		t.Fatal("register waited past its deadline")
+	}
+}
+
+// scopedModule connects a module that replies to every request with a
+// message to dest, returning the command gowon routes to it.
+func scopedModule(t *testing.T, token *ApiToken, dest string) *WebsocketCommand {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		for {
+			var f moduleFrame
+			if err := conn.ReadJSON(&f); err != nil {
+				return
+			}
+
+			out, _ := json.Marshal(message.Message{Module: "echo", Msg: "hi", Dest: dest})
+			_ = conn.WriteJSON(moduleFrame{Type: frameReply, Id: f.Id, Message: out})
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	assert.Nil(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	mc := newModuleConn(conn, token)
+	go NewModuleHub().serve(mc, nil)
+
+	return &WebsocketCommand{HttpCommand: HttpCommand{Command: "echo", Regex: ".*"}, conn: mc}
+}
+
+func TestWebsocketCommandScope(t *testing.T) {
+	token := &ApiToken{Networks: []string{"libera"}, Destinations: []string{"#mine"}, Kinds: []string{kindModule, kindMessage}}
+
+	cases := map[string]struct {
+		in       message.Message
+		expected bool
+	}{
+		"allowed channel": {
+			in:       message.Message{Msg: "hello", Dest: "#mine", Network: "libera"},
+			expected: true,
+		},
+		"other channel": {
+			in: message.Message{Msg: "hello", Dest: "#other", Network: "libera"},
+		},
+		"other network": {
+			in: message.Message{Msg: "hello", Dest: "#mine", Network: "oftc"},
+		},
+		"private message": {
+			in: message.Message{Msg: "hello", Dest: "gowon", Network: "libera"},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			cr := &CommandRouter{}
+			cr.AddRouterCommand(&WebsocketCommand{HttpCommand: HttpCommand{Command: "echo", Regex: ".*"}, conn: newModuleConn(nil, token)})
+
+			_, err := cr.RouteMessage(tc.in.Msg, &tc.in)
+			assert.Equal(t, tc.expected, err == nil)
+		})
+	}
+}
+
+func TestWebsocketCommandReplyScope(t *testing.T) {
+	token := &ApiToken{Networks: []string{"libera"}, Destinations: []string{"#mine"}, Kinds: []string{kindModule, kindMessage}}
+	in := &message.Message{Msg: ".echo hi", Dest: "#mine", Network: "libera", Command: "echo", Args: "hi"}
+
+	out := scopedModule(t, token, "#mine").Send(in)
+	assert.NotNil(t, out)
+	assert.Equal(t, "#mine", out.Dest)
+
+	assert.Nil(t, scopedModule(t, token, "#other").Send(in))
+	assert.Nil(t, scopedModule(t, token, "somenick").Send(in))
+}
+
+func TestModuleConnClosed(t *testing.T) {
+	mc := newModuleConn(nil, nil)
+	mc.close()
+
+	_, err := mc.Request(&message.Message{})
+	assert.EqualError(t, err, moduleGoneErrMsg)
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/ergochat/irc-go/ircmsg"
+	"github.com/gowon-irc/gowon/pkg/message"
+)
+
+const (
+	commandTypeMqtt = "mqtt"
+
+	mqttTimeout   = 10 * time.Second
+	mqttQueueSize = 256
+
+	mqttTimeoutErrMsg   = "timed out waiting for the mqtt broker"
+	mqttQueueFullErrMsg = "mqtt publish queue is full"
+)
+
+type mqttPublish struct {
+	topic string
+	body  []byte
+}
+
+type MqttTransport struct {
+	client mqtt.Client
+	prefix string
+	queue  chan mqttPublish
+	done   chan struct{}
+}
+
+func inputTopic(prefix, network, code string) string {
+	return strings.Join([]string{prefix, "input", network, code}, "/")
+}
+
+func outputTopic(prefix string) string {
+	return prefix + "/output"
+}
+
+func errorTopic(prefix string) string {
+	return prefix + "/errors"
+}
+
+type mqttError struct {
+	Status int    `json:"status"`
+	Error  string `json:"error"`
+}
+
+func waitToken(t mqtt.Token) error {
+	if !t.WaitTimeout(mqttTimeout) {
+		return errors.New(mqttTimeoutErrMsg)
+	}
+
+	return t.Error()
+}
+
+func NewMqttTransport(cfg *Config, networks Networks) (*MqttTransport, error) {
+	mt := &MqttTransport{
+		prefix: cfg.MqttPrefix,
+		queue:  make(chan mqttPublish, mqttQueueSize),
+		done:   make(chan struct{}),
+	}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker("tcp://" + cfg.Broker).
+		SetClientID(cfg.MqttClient).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetOrderMatters(false).
+		SetOnConnectHandler(func(c mqtt.Client) {
+			log.Printf("Connected to mqtt broker %s", cfg.Broker)
+
+			t := c.Subscribe(outputTopic(mt.prefix), 0, func(_ mqtt.Client, msg mqtt.Message) {
+				if _, status, errBody := deliverMessage(networks, nil, msg.Payload()); errBody != nil {
+					mt.publishError(status, fmt.Sprint(errBody["error"]))
+				}
+			})
+
+			if err := waitToken(t); err != nil {
+				log.Println(err)
+			}
+		}).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			log.Printf("Lost connection to mqtt broker %s: %s", cfg.Broker, err)
+		})
+
+	mt.client = mqtt.NewClient(opts)
+	go mt.publishLoop()
+
+	// with connect retry enabled this only fails on a timeout, after which
+	// the client keeps retrying in the background
+	if err := waitToken(mt.client.Connect()); err != nil {
+		return mt, fmt.Errorf("could not connect to mqtt broker %s: %w", cfg.Broker, err)
+	}
+
+	return mt, nil
+}
+
+// publishLoop waits on the broker so publishing from irc callbacks doesn't.
+func (mt *MqttTransport) publishLoop() {
+	for {
+		select {
+		case p := <-mt.queue:
+			if err := waitToken(mt.client.Publish(p.topic, 0, false, p.body)); err != nil {
+				log.Printf("Could not publish to %s: %s", p.topic, err)
+			}
+		case <-mt.done:
+			return
+		}
+	}
+}
+
+// Publish queues m to be sent to the broker, failing only if the queue is
+// full.
+func (mt *MqttTransport) Publish(topic string, m interface{}) error {
+	body, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case mt.queue <- mqttPublish{topic: topic, body: body}:
+		return nil
+	default:
+		return errors.New(mqttQueueFullErrMsg)
+	}
+}
+
+func (mt *MqttTransport) publishError(status int, errMsg string) {
+	log.Printf("Could not deliver message from mqtt: %s", errMsg)
+
+	if err := mt.Publish(errorTopic(mt.prefix), mqttError{Status: status, Error: errMsg}); err != nil {
+		log.Println(err)
+	}
+}
+
+func (mt *MqttTransport) Watch(n *Network) {
+	for _, e := range streamedEvents {
+		n.irccon.AddCallback(e, func(event ircmsg.Message) {
+			m := createMessage(n, event)
+
+			if err := mt.Publish(inputTopic(mt.prefix, n.Name, m.Code), m); err != nil {
+				log.Println(err)
+			}
+		})
+	}
+}
+
+func (mt *MqttTransport) Close() {
+	close(mt.done)
+	mt.client.Disconnect(250)
+}
+
+type MqttCommand struct {
+	HttpCommand
+	Topic     string
+	transport *MqttTransport
+}
+
+func (mt *MqttTransport) Command(cmd *Command) *MqttCommand {
+	return &MqttCommand{
+		HttpCommand: HttpCommand{
+			Command:  cmd.Command,
+			Regex:    cmd.Regex,
+			Help:     cmd.Help,
+			Priority: cmd.Priority,
+		},
+		Topic:     cmd.Topic,
+		transport: mt,
+	}
+}
+
+// Replies to mqtt commands arrive asynchronously on the output topic, so
+// Send only reports a full publish queue.
+func (mc *MqttCommand) Send(in *message.Message) *message.Message {
+	if err := mc.transport.Publish(mc.Topic, in); err != nil {
+		log.Printf("Command %s failed: %s", in.Command, err)
+		in.Msg = fmt.Sprintf("{red}Error: request to %s failed{clear}", in.Command)
+		return in
+	}
+
+	return nil
+}
+
+func (mc *MqttCommand) GetHelp() string {
+	if mc.Help != "" {
+		return fmt.Sprintf("{cyan}%s{clear}: %s", mc.Command, mc.Help)
+	}
+
+	return fmt.Sprintf("{cyan}%s{clear}: no help found", mc.Command)
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/ergochat/irc-go/ircevent"
+	"github.com/gowon-irc/gowon/pkg/message"
+	"github.com/stretchr/testify/assert"
+)
+
+// testBroker is a minimal MQTT 3.1.1 broker supporting QoS 0 only.
+type testBroker struct {
+	ln   net.Listener
+	mu   sync.Mutex
+	subs map[net.Conn][]string
+}
+
+func newTestBroker(t *testing.T) *testBroker {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	b := &testBroker{ln: ln, subs: make(map[net.Conn][]string)}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			go b.serve(conn)
+		}
+	}()
+
+	return b
+}
+
+func (b *testBroker) Addr() string {
+	return b.ln.Addr().String()
+}
+
+func (b *testBroker) Close() {
+	b.ln.Close()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for conn := range b.subs {
+		conn.Close()
+	}
+}
+
+func topicMatches(filter, topic string) bool {
+	fs, ts := strings.Split(filter, "/"), strings.Split(topic, "/")
+
+	for i, f := range fs {
+		if f == "#" {
+			return true
+		}
+
+		if i >= len(ts) || (f != "+" && f != ts[i]) {
+			return false
+		}
+	}
+
+	return len(fs) == len(ts)
+}
+
+func readPacket(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	length, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	body := make([]byte, length)
+	_, err = io.ReadFull(r, body)
+
+	return header, body, err
+}
+
+func packet(header byte, body []byte) []byte {
+	return append(binary.AppendUvarint([]byte{header}, uint64(len(body))), body...)
+}
+
+func readString(body []byte) (string, []byte) {
+	n := binary.BigEndian.Uint16(body)
+	return string(body[2 : 2+n]), body[2+n:]
+}
+
+func (b *testBroker) serve(conn net.Conn) {
+	b.mu.Lock()
+	b.subs[conn] = nil
+	b.mu.Unlock()
+
+	defer func() {
+		b.mu.Lock()
+		delete(b.subs, conn)
+		b.mu.Unlock()
+		conn.Close()
+	}()
+
+	r := bufio.NewReader(conn)
+
+	for {
+		header, body, err := readPacket(r)
+		if err != nil {
+			return
+		}
+
+		switch header >> 4 {
+		case 1: // CONNECT
+			conn.Write(packet(0x20, []byte{0, 0}))
+		case 3: // PUBLISH
+			topic, _ := readString(body)
+			b.publish(topic, packet(0x30, body))
+		case 8: // SUBSCRIBE
+			id, rest := body[:2], body[2:]
+			granted := []byte{}
+
+			b.mu.Lock()
+			for len(rest) > 0 {
+				var filter string
+				filter, rest = readString(rest)
+				rest = rest[1:]
+
+				b.subs[conn] = append(b.subs[conn], filter)
+				granted = append(granted, 0)
+			}
+			b.mu.Unlock()
+
+			conn.Write(packet(0x90, append(id, granted...)))
+		case 10: // UNSUBSCRIBE
+			conn.Write(packet(0xb0, body[:2]))
+		case 12: // PINGREQ
+			conn.Write(packet(0xd0, nil))
+		case 14: // DISCONNECT
+			return
+		}
+	}
+}
+
+func (b *testBroker) publish(topic string, p []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for conn, filters := range b.subs {
+		for _, f := range filters {
+			if topicMatches(f, topic) {
+				conn.Write(p)
+				break
+			}
+		}
+	}
+}
+
+func subscribeTest(t *testing.T, broker, topic string) (<-chan mqtt.Message, func()) {
+	client := mqtt.NewClient(mqtt.NewClientOptions().AddBroker("tcp://" + broker).SetClientID("test-" + topic))
+	assert.Nil(t, waitToken(client.Connect()))
+
+	msgs := make(chan mqtt.Message, 10)
+	assert.Nil(t, waitToken(client.Subscribe(topic, 0, func(_ mqtt.Client, m mqtt.Message) {
+		msgs <- m
+	})))
+
+	return msgs, func() { client.Disconnect(0) }
+}
+
+func receive(t *testing.T, msgs <-chan mqtt.Message) mqtt.Message {
+	select {
+	case m := <-msgs:
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatal("no mqtt message received")
+		return nil
+	}
+}
+
+func TestTopicMatches(t *testing.T) {
+	assert.True(t, topicMatches("gowon/input/#", "gowon/input/libera/PRIVMSG"))
+	assert.True(t, topicMatches("gowon/+/libera/+", "gowon/input/libera/JOIN"))
+	assert.False(t, topicMatches("gowon/output", "gowon/output/extra"))
+	assert.False(t, topicMatches("gowon/input/+", "gowon/output/x"))
+}
+
+func TestInputTopic(t *testing.T) {
+	assert.Equal(t, "gowon/input/libera/PRIVMSG", inputTopic("gowon", "libera", "PRIVMSG"))
+}
+
+func TestMqttTransport(t *testing.T) {
+	b := newTestBroker(t)
+	defer b.Close()
+
+	cm := NewConfigManager()
+	cm.MergedConfig = &Config{}
+
+	irccon := &ircevent.Connection{Nick: "gowon", User: "gowon"}
+	n := &Network{Name: defaultNetwork, irccon: irccon, cm: cm, chm: NewChannelManager(irccon)}
+	n.chm.setPresent("#gowon", true)
+
+	commands, doneCommands := subscribeTest(t, b.Addr(), "modules/#")
+	defer doneCommands()
+
+	errs, doneErrs := subscribeTest(t, b.Addr(), errorTopic("gowon"))
+	defer doneErrs()
+
+	mt, err := NewMqttTransport(&Config{Broker: b.Addr(), MqttPrefix: "gowon", MqttClient: "gowon"}, Networks{n})
+	assert.Nil(t, err)
+	defer mt.Close()
+
+	cmd := mt.Command(&Command{Command: "echo", Type: commandTypeMqtt, Topic: "modules/echo"})
+	assert.True(t, cmd.Match(".echo hi"))
+	assert.Equal(t, "{cyan}echo{clear}: no help found", cmd.GetHelp())
+	assert.Nil(t, cmd.Send(&message.Message{Msg: ".echo hi", Dest: "#gowon", Command: "echo", Args: "hi"}))
+
+	published := receive(t, commands)
+	assert.Equal(t, "modules/echo", published.Topic())
+
+	var in message.Message
+	assert.Nil(t, json.Unmarshal(published.Payload(), &in))
+	assert.Equal(t, "hi", in.Args)
+
+	// the subscription to the output topic is made once the client connects
+	assert.Eventually(t, func() bool {
+		out, _ := json.Marshal(message.Message{Module: "echo", Msg: "hi", Dest: "#elsewhere"})
+		mt.client.Publish(outputTopic("gowon"), 0, false, out)
+
+		select {
+		case m := <-errs:
+			var e mqttError
+			assert.Nil(t, json.Unmarshal(m.Payload(), &e))
+			assert.Equal(t, notJoinedErrMsg, e.Error)
+			return true
+		case <-time.After(100 * time.Millisecond):
+			return false
+		}
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestMqttPublishQueueFull(t *testing.T) {
+	mt := &MqttTransport{queue: make(chan mqttPublish, 1)}
+
+	assert.Nil(t, mt.Publish("gowon/input", &message.Message{Msg: "first"}))
+	assert.EqualError(t, mt.Publish("gowon/input", &message.Message{Msg: "second"}), mqttQueueFullErrMsg)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"unicode"
+
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+const (
+	capMultiline    = "draft/multiline"
+	multilineConcat = "draft/multiline-concat"
+)
+
+var batchCounter uint64
+
+func multilineLimits(value string) (maxBytes, maxLines int) {
+	for _, kv := range strings.Split(value, ",") {
+		k, v, _ := strings.Cut(kv, "=")
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			continue
+		}
+
+		switch k {
+		case "max-bytes":
+			maxBytes = i
+		case "max-lines":
+			maxLines = i
+		}
+	}
+
+	return maxBytes, maxLines
+}
+
+// concatChunks splits line like splitMsg, but puts the whitespace removed at
+// each split back at the start of the next chunk, since multiline-concat
+// joins chunks without a separator.
+func concatChunks(line string, length int) (out []string) {
+	sep := ""
+
+	for {
+		chunk, remaining := chunkMsg(line, length-len(sep))
+		out = append(out, sep+chunk)
+
+		if remaining == "" {
+			return out
+		}
+
+		visible := stripFormatting(line)
+		rest := visible[min(len(stripFormatting(chunk)), len(visible)):]
+
+		sep = ""
+		if rest != strings.TrimLeftFunc(rest, unicode.IsSpace) {
+			sep = " "
+		}
+
+		line = remaining
+	}
+}
+
+func multilineBatch(ref, dest string, lines []string, length int, tags map[string]string) (out []ircmsg.Message, bytes, count int) {
+	out = append(out, ircmsg.MakeMessage(tags, "", "BATCH", "+"+ref, capMultiline, dest))
+
+	for n, line := range lines {
+		if n > 0 {
+			bytes++
+		}
+
+		for i, chunk := range concatChunks(line, length) {
+			t := map[string]string{"batch": ref}
+			if i > 0 {
+				t[multilineConcat] = ""
+			}
+
+			out = append(out, ircmsg.MakeMessage(t, "", "PRIVMSG", dest, chunk))
+			bytes += len(chunk)
+			count++
+		}
+	}
+
+	out = append(out, ircmsg.MakeMessage(nil, "", "BATCH", "-"+ref))
+
+	return out, bytes, count
+}
+
+func (n *Network) sendMultiline(dest string, lines []string, budget int, tags map[string]string) bool {
+	value, ok := n.irccon.AcknowledgedCaps()[capMultiline]
+	if !ok || !n.HasCap("batch") {
+		return false
+	}
+
+	ref := strconv.FormatUint(atomic.AddUint64(&batchCounter, 1), 36)
+	msgs, bytes, count := multilineBatch(ref, dest, lines, budget, tags)
+
+	if count < 2 {
+		return false
+	}
+
+	maxBytes, maxLines := multilineLimits(value)
+	if (maxBytes > 0 && bytes > maxBytes) || (maxLines > 0 && count > maxLines) {
+		return false
+	}
+
+	for _, m := range msgs {
+		if err := n.irccon.SendIRCMessage(m); err != nil {
+			log.Printf("Could not send multiline batch to %s: %s", dest, err)
+			return true
+		}
+	}
+
+	return true
+}
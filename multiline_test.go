@@ -0,0 +1,120 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMultilineLimits(t *testing.T) {
+	cases := map[string]struct {
+		value    string
+		maxBytes int
+		maxLines int
+	}{
+		"both limits": {
+			value:    "max-bytes=4096,max-lines=24",
+			maxBytes: 4096,
+			maxLines: 24,
+		},
+		"bytes only": {
+			value:    "max-bytes=4096",
+			maxBytes: 4096,
+			maxLines: 0,
+		},
+		"empty": {
+			value:    "",
+			maxBytes: 0,
+			maxLines: 0,
+		},
+		"invalid value": {
+			value:    "max-bytes=lots,max-lines=2",
+			maxBytes: 0,
+			maxLines: 2,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			maxBytes, maxLines := multilineLimits(tc.value)
+
+			assert.Equal(t, tc.maxBytes, maxBytes)
+			assert.Equal(t, tc.maxLines, maxLines)
+		})
+	}
+}
+
+func TestMultilineBatch(t *testing.T) {
+	lines := []string{"first line", strings.Repeat("a ", 10) + "b", "third"}
+	tags := map[string]string{"+draft/reply": "abc"}
+
+	msgs, bytes, count := multilineBatch("1", "#chat", lines, 10, tags)
+
+	assert.Equal(t, 7, len(msgs))
+	assert.Equal(t, "BATCH", msgs[0].Command)
+	assert.Equal(t, []string{"+1", capMultiline, "#chat"}, msgs[0].Params)
+	assert.Equal(t, map[string]string{"+draft/reply": "abc"}, msgs[0].AllTags())
+
+	expected := []struct {
+		text   string
+		concat bool
+	}{
+		{text: "first line"},
+		{text: "a a a a a"},
+		{text: " a a a a a", concat: true},
+		{text: " b", concat: true},
+		{text: "third"},
+	}
+
+	for n, e := range expected {
+		m := msgs[n+1]
+		assert.Equal(t, "PRIVMSG", m.Command)
+		assert.Equal(t, []string{"#chat", e.text}, m.Params)
+
+		ok, ref := m.GetTag("batch")
+		assert.True(t, ok)
+		assert.Equal(t, "1", ref)
+
+		concat, _ := m.GetTag(multilineConcat)
+		assert.Equal(t, e.concat, concat)
+	}
+
+	assert.Equal(t, "BATCH", msgs[6].Command)
+	assert.Equal(t, []string{"-1"}, msgs[6].Params)
+	assert.Equal(t, 5, count)
+	assert.Equal(t, 10+9+10+2+5+2, bytes)
+}
+
+func TestConcatChunks(t *testing.T) {
+	cases := map[string]struct {
+		line     string
+		expected []string
+	}{
+		"split on spaces": {
+			line:     "a a a a a a a a a a",
+			expected: []string{"a a a a a", " a a a a a"},
+		},
+		"split inside a word": {
+			line:     "abcdefghijklmno",
+			expected: []string{"abcdefghij", "klmno"},
+		},
+		"coloured": {
+			line:     "\x0302blue words\x0399 here",
+			expected: []string{"\x0302blue\x0399", " \x0302wor\x0399", "\x0302ds\x0399", " here"},
+		},
+		"short": {
+			line:     "short",
+			expected: []string{"short"},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			chunks := concatChunks(tc.line, 10)
+
+			assert.Equal(t, tc.expected, chunks)
+			assert.Equal(t, stripFormatting(tc.line), stripFormatting(strings.Join(chunks, "")))
+		})
+	}
+}
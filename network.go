@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/ergochat/irc-go/ircevent"
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+const (
+	defaultNetwork = "default"
+
+	unknownNetworkErrMsg = "unknown network"
+)
+
+type Network struct {
+	Name       string
+	irccon     *ircevent.Connection
+	cm         *ConfigManager
+	chm        *ChannelManager
+	nm         *NickManager
+	supervisor *Supervisor
+
+	mu       sync.Mutex
+	userhost string
+}
+
+func NewNetwork(ncfg NetworkConfig, cm *ConfigManager, cr *CommandRouter, ps PasteStore) (*Network, error) {
+	cfg := cm.Config()
+
+	irccon := &ircevent.Connection{
+		Server:      ncfg.Server,
+		Nick:        ncfg.Nick,
+		User:        ncfg.User,
+		Debug:       cfg.Debug,
+		RequestCaps: requestedCaps(),
+		KeepAlive:   cfg.PingInterval,
+		Timeout:     cfg.PingTimeout,
+		MaxLineLen:  cfg.MaxLineLength,
+	}
+
+	if irccon.MaxLineLen <= 0 {
+		irccon.MaxLineLen = defaultMaxLineLength
+	}
+	// ircevent.VerboseCallbackHandler = cfg.Verbose
+
+	irccon.UseTLS = ncfg.UseTLS
+	if ncfg.UseTLS || ncfg.SASLMech == saslExternal {
+		tc, err := createTLSConfig(ncfg)
+		if err != nil {
+			return nil, err
+		}
+
+		irccon.TLSConfig = tc
+	}
+
+	switch {
+	case ncfg.SASLMech == saslExternal:
+		irccon.UseTLS = false
+		irccon.DialContext = createSASLExternalDialer(irccon.TLSConfig, ncfg.SASLAccount)
+	case ncfg.Password != "":
+		irccon.UseSASL = true
+		irccon.SASLLogin = saslAccount(ncfg)
+		irccon.SASLPassword = ncfg.Password
+	}
+
+	n := &Network{
+		Name:   ncfg.Name,
+		irccon: irccon,
+		cm:     cm,
+		chm:    NewChannelManager(irccon),
+		nm:     NewNickManager(irccon, cm, ncfg.Name),
+	}
+
+	n.chm.SetKeys(ncfg.ChannelKeys)
+	n.chm.SetStripFormatting(ncfg.StripFormatting)
+	n.chm.Sync(nil, ncfg.Channels)
+
+	irccon.AddConnectCallback(func(e ircmsg.Message) {
+		n.chm.HandleConnect(e)
+		n.chm.JoinAll()
+	})
+
+	n.supervisor = NewSupervisor(ncfg.Name, irccon, cfg)
+	n.trackUserhost(irccon)
+
+	irccon.AddCallback("NICK", n.nm.HandleNickFreed)
+	irccon.AddCallback("QUIT", n.nm.HandleNickFreed)
+
+	irccon.AddCallback("JOIN", n.chm.HandleJoin)
+	irccon.AddCallback("PART", n.chm.HandlePart)
+	irccon.AddCallback("KICK", n.chm.HandleKick)
+	for _, e := range joinErrors {
+		irccon.AddCallback(e, n.chm.HandleJoinError)
+	}
+	irccon.AddCallback("MODE", n.chm.HandleMode)
+	irccon.AddCallback(ircevent.RPL_CHANNELMODEIS, n.chm.HandleChannelModeIs)
+
+	irccon.AddCallback("PRIVMSG", createIrcHandler(n, cr, ps))
+	irccon.AddCallback("INVITE", createInviteHandler(cm, n))
+
+	return n, nil
+}
+
+func (n *Network) Join(channel, key string) error {
+	return n.chm.Join(channel, key)
+}
+
+// Part leaves channel and stops it being rejoined from the invites file.
+func (n *Network) Part(channel, reason string) error {
+	if err := n.chm.Part(channel, reason); err != nil {
+		return err
+	}
+
+	if f := n.cm.Config().InvitesFile; f != "" {
+		return forgetChannel(f, n.Name, channel)
+	}
+
+	return nil
+}
+
+func (n *Network) SendMessage(dest, msg string, tags map[string]string) {
+	tags = n.outgoingTags(tags)
+	lines := strings.Split(msg, "\n")
+	budget := n.lineBudget(dest)
+	strip := n.chm.StripFormatting(dest)
+
+	for i, line := range lines {
+		lines[i] = colourMsg(line)
+		if strip {
+			lines[i] = stripFormatting(lines[i])
+		}
+	}
+
+	if n.sendMultiline(dest, lines, budget, tags) {
+		return
+	}
+
+	for _, line := range lines {
+		for _, sm := range splitMsg(line, budget) {
+			err := n.irccon.SendWithTags(tags, "PRIVMSG", dest, sm)
+			if err != nil {
+				log.Println(err)
+			}
+		}
+	}
+}
+
+func (n *Network) Run() {
+	go n.nm.Loop()
+
+	n.supervisor.Run()
+}
+
+type Networks []*Network
+
+func (ns Networks) Get(name string) (*Network, error) {
+	if name == "" && len(ns) > 0 {
+		return ns[0], nil
+	}
+
+	for _, n := range ns {
+		if n.Name == name {
+			return n, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%s: %s", unknownNetworkErrMsg, name)
+}
+
+func (ns Networks) Sync(old, new *Config) {
+	for _, n := range ns {
+		oldCfg, _ := old.GetNetwork(n.Name)
+		newCfg, ok := new.GetNetwork(n.Name)
+		if !ok {
+			log.Printf("Network %s has been removed from the config, restart to disconnect from it", n.Name)
+			continue
+		}
+
+		n.chm.SetKeys(newCfg.ChannelKeys)
+		n.chm.SetStripFormatting(newCfg.StripFormatting)
+		n.chm.Sync(oldCfg.Channels, newCfg.Channels)
+	}
+}
+
+func (ns Networks) Statuses() []ConnectionStatus {
+	out := []ConnectionStatus{}
+
+	for _, n := range ns {
+		out = append(out, n.supervisor.Status())
+	}
+
+	return out
+}
+
+func (ns Networks) Run() {
+	var wg sync.WaitGroup
+
+	for _, n := range ns {
+		wg.Add(1)
+
+		go func(n *Network) {
+			defer wg.Done()
+			n.Run()
+		}(n)
+	}
+
+	wg.Wait()
+}
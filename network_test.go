@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ergochat/irc-go/ircmsg"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNetworksGet(t *testing.T) {
+	networks := Networks{{Name: defaultNetwork}, {Name: "libera"}}
+
+	cases := map[string]struct {
+		name     string
+		expected string
+		err      bool
+	}{
+		"no name": {
+			name:     "",
+			expected: defaultNetwork,
+		},
+		"default network": {
+			name:     defaultNetwork,
+			expected: defaultNetwork,
+		},
+		"named network": {
+			name:     "libera",
+			expected: "libera",
+		},
+		"unknown network": {
+			name: "oftc",
+			err:  true,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			n, err := networks.Get(tc.name)
+
+			if tc.err {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, n.Name)
+		})
+	}
+}
+
+// testIrcServer is just enough of an irc server to register a client,
+// answer capability negotiation and record what the client sends.
+type testIrcServer struct {
+	ln     net.Listener
+	caps   string
+	taken  []string
+	banned []string
+	lines  chan string
+
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func newTestIrcServer(t *testing.T, caps string) *testIrcServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	s := &testIrcServer{ln: ln, caps: caps, lines: make(chan string, 1000)}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			s.mu.Lock()
+			s.conns = append(s.conns, conn)
+			s.mu.Unlock()
+
+			go s.serve(conn)
+		}
+	}()
+
+	t.Cleanup(s.close)
+
+	return s
+}
+
+func (s *testIrcServer) addr() string {
+	return s.ln.Addr().String()
+}
+
+func (s *testIrcServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	reply := func(line string) {
+		_, _ = conn.Write([]byte(line + "\r\n"))
+	}
+
+	nick, user, registered := "*", "", false
+	scanner := bufio.NewScanner(conn)
+
+	for scanner.Scan() {
+		s.lines <- scanner.Text()
+
+		m, err := ircmsg.ParseLine(scanner.Text())
+		if err != nil {
+			continue
+		}
+
+		switch m.Command {
+		case "CAP":
+			switch m.Params[0] {
+			case "LS":
+				reply(fmt.Sprintf(":irc.test CAP * LS :%s", s.caps))
+			case "REQ":
+				reply(fmt.Sprintf(":irc.test CAP * ACK :%s", m.Params[1]))
+			}
+		case "NICK":
+			if slices.Contains(s.taken, m.Params[0]) {
+				reply(fmt.Sprintf(":irc.test 433 %s %s :Nickname is already in use", nick, m.Params[0]))
+				continue
+			}
+			if registered {
+				reply(fmt.Sprintf(":%s!%s@test.host NICK %s", nick, user, m.Params[0]))
+			}
+			nick = m.Params[0]
+		case "USER":
+			user = m.Params[0]
+		}
+
+		if !registered && nick != "*" && user != "" {
+			registered = true
+			reply(fmt.Sprintf(":irc.test 001 %s :Welcome to the test network %s!%s@test.host", nick, nick, user))
+			reply(fmt.Sprintf(":irc.test 422 %s :MOTD File is missing", nick))
+		}
+
+		switch m.Command {
+		case "PING":
+			reply(fmt.Sprintf(":irc.test PONG irc.test :%s", m.Params[0]))
+		case "PRIVMSG":
+			if strings.Contains(s.caps, capEchoMessage) {
+				m.Source = nick + "!" + nick + "@test.host"
+				line, _ := m.Line()
+				reply(strings.TrimSuffix(line, "\r\n"))
+			}
+		case "JOIN":
+			if slices.Contains(s.banned, m.Params[0]) {
+				reply(fmt.Sprintf(":irc.test 474 %s %s :Cannot join channel (+b)", nick, m.Params[0]))
+				continue
+			}
+			reply(fmt.Sprintf(":%s!%s@test.host JOIN %s", nick, user, m.Params[0]))
+		case "PART":
+			reply(fmt.Sprintf(":%s!%s@test.host PART %s", nick, user, m.Params[0]))
+		case "QUIT":
+			return
+		}
+	}
+}
+
+// send writes a line to the most recent client.
+func (s *testIrcServer) send(line string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.conns) > 0 {
+		_, _ = s.conns[len(s.conns)-1].Write([]byte(line + "\r\n"))
+	}
+}
+
+// drop closes every client connection without closing the listener.
+func (s *testIrcServer) drop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, c := range s.conns {
+		c.Close()
+	}
+	s.conns = nil
+}
+
+func (s *testIrcServer) close() {
+	s.ln.Close()
+	s.drop()
+}
+
+// expect returns the next line from the client starting with prefix,
+// skipping anything else it sent first.
+func (s *testIrcServer) expect(t *testing.T, prefix string) string {
+	timeout := time.After(5 * time.Second)
+
+	for {
+		select {
+		case line := <-s.lines:
+			if strings.HasPrefix(line, prefix) {
+				return line
+			}
+		case <-timeout:
+			t.Fatalf("client never sent a line starting with %q", prefix)
+			return ""
+		}
+	}
+}
+
+func newTestNetwork(t *testing.T, s *testIrcServer, cfg *Config) *Network {
+	cfg.Server = s.addr()
+	cfg.User = "gowon"
+	if cfg.Nick == "" {
+		cfg.Nick = "gowon"
+	}
+	if cfg.Channels == nil {
+		cfg.Channels = []string{"#gowon"}
+	}
+
+	cm := NewConfigManager()
+	cm.MergedConfig = cfg
+
+	n, err := NewNetwork(cfg.defaultNetwork(), cm, &CommandRouter{}, nil)
+	assert.Nil(t, err)
+
+	return n
+}
+
+// connectTestNetwork registers the network with the server, leaving the
+// connection to be closed when the test ends.
+func connectTestNetwork(t *testing.T, n *Network) {
+	assert.Nil(t, n.irccon.Connect())
+
+	t.Cleanup(func() {
+		n.irccon.Quit()
+	})
+}
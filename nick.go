@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ergochat/irc-go/ircevent"
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+const (
+	nickServNone   = "none"
+	nickServRegain = "regain"
+	nickServGhost  = "ghost"
+)
+
+func nextAltNick(rejected, nick string, alts []string) string {
+	if strings.EqualFold(rejected, nick) && len(alts) > 0 {
+		return alts[0]
+	}
+
+	for n, a := range alts {
+		if strings.EqualFold(rejected, a) && n+1 < len(alts) {
+			return alts[n+1]
+		}
+	}
+
+	return ""
+}
+
+func regainCommands(mode, nick, password string) [][]string {
+	if password == "" {
+		mode = nickServNone
+	}
+
+	switch mode {
+	case nickServRegain:
+		return [][]string{
+			{"PRIVMSG", "NickServ", fmt.Sprintf("REGAIN %s %s", nick, password)},
+		}
+	case nickServGhost:
+		return [][]string{
+			{"PRIVMSG", "NickServ", fmt.Sprintf("GHOST %s %s", nick, password)},
+			{"NICK", nick},
+		}
+	default:
+		return [][]string{
+			{"NICK", nick},
+		}
+	}
+}
+
+func addressedMessage(msg string, nicks []string) (string, bool) {
+	for _, n := range nicks {
+		if n == "" || len(msg) <= len(n) || !strings.EqualFold(msg[:len(n)], n) {
+			continue
+		}
+
+		if !strings.ContainsAny(msg[len(n):len(n)+1], ":,") {
+			continue
+		}
+
+		rest := strings.TrimSpace(msg[len(n)+1:])
+		if rest == "" {
+			continue
+		}
+
+		if !strings.HasPrefix(rest, ".") {
+			rest = "." + rest
+		}
+
+		return rest, true
+	}
+
+	return msg, false
+}
+
+type NickManager struct {
+	irccon   *ircevent.Connection
+	cm       *ConfigManager
+	network  string
+	once     sync.Once
+	fallback int
+}
+
+func NewNickManager(irccon *ircevent.Connection, cm *ConfigManager, network string) *NickManager {
+	nm := &NickManager{
+		irccon:  irccon,
+		cm:      cm,
+		network: network,
+	}
+
+	dial := irccon.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+
+	// ircevent adds its own 433/437 handling, which tries <nick>_<n>, on the
+	// first Connect before dialling. It is swapped for ours here so only one
+	// NICK is sent for each rejected nick.
+	irccon.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		nm.once.Do(func() {
+			for _, code := range []string{ircevent.ERR_NICKNAMEINUSE, ircevent.ERR_UNAVAILRESOURCE} {
+				irccon.ClearCallback(code)
+				irccon.AddCallback(code, nm.HandleUnavailable)
+			}
+		})
+
+		return dial(ctx, network, addr)
+	}
+
+	return nm
+}
+
+func (nm *NickManager) config() NetworkConfig {
+	cfg, _ := nm.cm.Config().GetNetwork(nm.network)
+	return cfg
+}
+
+func (nm *NickManager) Nicks() []string {
+	return []string{nm.irccon.CurrentNick(), nm.config().Nick}
+}
+
+func (nm *NickManager) Regain() {
+	cfg := nm.config()
+
+	if !nm.irccon.Connected() || nm.irccon.CurrentNick() == "" || strings.EqualFold(nm.irccon.CurrentNick(), cfg.Nick) {
+		return
+	}
+
+	log.Printf("Attempting to regain nick %s", cfg.Nick)
+
+	for _, c := range regainCommands(cfg.NickServ, cfg.Nick, cfg.Password) {
+		if err := nm.irccon.Send(c[0], c[1:]...); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+func (nm *NickManager) HandleUnavailable(event ircmsg.Message) {
+	if nm.irccon.CurrentNick() != "" || len(event.Params) < 2 {
+		return
+	}
+
+	cfg := nm.config()
+
+	alt := nextAltNick(event.Params[1], cfg.Nick, cfg.AltNicks)
+	if alt == "" {
+		alt = fmt.Sprintf("%s_%d", cfg.Nick, nm.fallback)
+		nm.fallback++
+	}
+
+	log.Printf("Nick %s is unavailable, trying %s", event.Params[1], alt)
+
+	if err := nm.irccon.Send("NICK", alt); err != nil {
+		log.Println(err)
+	}
+}
+
+func (nm *NickManager) HandleNickFreed(event ircmsg.Message) {
+	if strings.EqualFold(event.Nick(), nm.config().Nick) {
+		nm.Regain()
+	}
+}
+
+// Loop asks NickServ for the nick back periodically. Without NickServ there
+// is nothing to add, as ircevent already sends NICK for the configured nick
+// on every keepalive.
+func (nm *NickManager) Loop() {
+	for {
+		interval := nm.cm.Config().RegainInterval
+		if interval <= 0 {
+			interval = time.Minute
+		}
+
+		time.Sleep(interval)
+
+		cfg := nm.config()
+		if cfg.Password == "" || cfg.NickServ == "" || cfg.NickServ == nickServNone {
+			continue
+		}
+
+		nm.Regain()
+	}
+}
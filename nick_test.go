@@ -0,0 +1,200 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ergochat/irc-go/ircmsg"
+	"github.com/gowon-irc/gowon/pkg/message"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNextAltNick(t *testing.T) {
+	alts := []string{"gowon_", "gowon__"}
+
+	cases := map[string]struct {
+		rejected string
+		alts     []string
+		expected string
+	}{
+		"primary nick rejected": {
+			rejected: "gowon",
+			alts:     alts,
+			expected: "gowon_",
+		},
+		"first alt rejected": {
+			rejected: "gowon_",
+			alts:     alts,
+			expected: "gowon__",
+		},
+		"last alt rejected": {
+			rejected: "gowon__",
+			alts:     alts,
+			expected: "",
+		},
+		"no alts": {
+			rejected: "gowon",
+			alts:     []string{},
+			expected: "",
+		},
+		"unknown nick rejected": {
+			rejected: "gowon_0",
+			alts:     alts,
+			expected: "",
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, nextAltNick(tc.rejected, "gowon", tc.alts))
+		})
+	}
+}
+
+func TestRegainCommands(t *testing.T) {
+	cases := map[string]struct {
+		mode     string
+		password string
+		expected [][]string
+	}{
+		"no nickserv": {
+			mode:     nickServNone,
+			password: "pass",
+			expected: [][]string{{"NICK", "gowon"}},
+		},
+		"regain": {
+			mode:     nickServRegain,
+			password: "pass",
+			expected: [][]string{{"PRIVMSG", "NickServ", "REGAIN gowon pass"}},
+		},
+		"ghost": {
+			mode:     nickServGhost,
+			password: "pass",
+			expected: [][]string{{"PRIVMSG", "NickServ", "GHOST gowon pass"}, {"NICK", "gowon"}},
+		},
+		"regain without password": {
+			mode:     nickServRegain,
+			password: "",
+			expected: [][]string{{"NICK", "gowon"}},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, regainCommands(tc.mode, "gowon", tc.password))
+		})
+	}
+}
+
+func TestAddressedMessage(t *testing.T) {
+	nicks := []string{"gowon_", "gowon"}
+
+	cases := map[string]struct {
+		msg       string
+		expected  string
+		addressed bool
+	}{
+		"primary nick with colon": {
+			msg:       "gowon: rev hello",
+			expected:  ".rev hello",
+			addressed: true,
+		},
+		"current nick with comma": {
+			msg:       "gowon_, rev hello",
+			expected:  ".rev hello",
+			addressed: true,
+		},
+		"already a command": {
+			msg:       "Gowon: .rev hello",
+			expected:  ".rev hello",
+			addressed: true,
+		},
+		"nick prefix of another word": {
+			msg:       "gowonbot: rev hello",
+			expected:  "gowonbot: rev hello",
+			addressed: false,
+		},
+		"nick with nothing after": {
+			msg:       "gowon:",
+			expected:  "gowon:",
+			addressed: false,
+		},
+		"not addressed": {
+			msg:       ".rev hello",
+			expected:  ".rev hello",
+			addressed: false,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			msg, addressed := addressedMessage(tc.msg, nicks)
+
+			assert.Equal(t, tc.expected, msg)
+			assert.Equal(t, tc.addressed, addressed)
+		})
+	}
+}
+
+func TestNickUnavailable(t *testing.T) {
+	cases := map[string]struct {
+		altNicks []string
+		expected []string
+	}{
+		"alt nick": {
+			altNicks: []string{"gowon2"},
+			expected: []string{"NICK gowon", "NICK gowon2"},
+		},
+		"no alt nicks left": {
+			expected: []string{"NICK gowon", "NICK gowon_0"},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			s := newTestIrcServer(t, "")
+			s.taken = []string{"gowon"}
+
+			n := newTestNetwork(t, s, &Config{AltNicks: tc.altNicks})
+			connectTestNetwork(t, n)
+			assert.Nil(t, n.irccon.Send("PING", "sync"))
+
+			nicks := []string{}
+			for line := s.expect(t, ""); line != "PING sync"; line = s.expect(t, "") {
+				if strings.HasPrefix(line, "NICK") {
+					nicks = append(nicks, line)
+				}
+			}
+
+			assert.Equal(t, tc.expected, nicks)
+			assert.Equal(t, strings.TrimPrefix(tc.expected[1], "NICK "), n.irccon.CurrentNick())
+		})
+	}
+}
+
+func TestAddressedCommandRoutes(t *testing.T) {
+	s := newTestIrcServer(t, "")
+	n := newTestNetwork(t, s, &Config{})
+	connectTestNetwork(t, n)
+
+	received := make(chan *message.Message, 1)
+
+	cr := &CommandRouter{}
+	cr.AddInternal("rev", "", func(in *message.Message) string {
+		received <- in
+		return "olleh"
+	})
+
+	handler := createIrcHandler(n, cr, nil)
+	handler(ircmsg.MakeMessage(nil, "nick!user@host", "PRIVMSG", "#gowon", "gowon: rev hello"))
+
+	m := <-received
+	assert.Equal(t, "gowon: rev hello", m.Msg)
+	assert.Equal(t, "rev", m.Command)
+	assert.Equal(t, "hello", m.Args)
+	assert.Equal(t, "#gowon", m.Dest)
+
+	out, err := ircmsg.ParseLine(s.expect(t, "PRIVMSG"))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"#gowon", "olleh"}, out.Params)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gowon-irc/gowon/pkg/message"
+)
+
+const (
+	pasteNone  = "none"
+	pasteLocal = "local"
+
+	invalidPasteIdErrMsg = "invalid paste id"
+	pasteNotFoundErrMsg  = "paste not found"
+)
+
+var pasteIdRegex = regexp.MustCompile(`^[0-9a-f]{16}$`)
+
+type PasteStore interface {
+	Paste(text string) (url string, err error)
+}
+
+type LocalPasteStore struct {
+	Dir     string
+	BaseURL string
+}
+
+func NewPasteStore(cfg *Config) (PasteStore, error) {
+	switch cfg.PasteBackend {
+	case pasteLocal:
+		if err := os.MkdirAll(cfg.PasteDir, 0o755); err != nil {
+			return nil, err
+		}
+
+		return &LocalPasteStore{Dir: cfg.PasteDir, BaseURL: strings.TrimSuffix(cfg.PasteURL, "/")}, nil
+	default:
+		return nil, nil
+	}
+}
+
+func (ps *LocalPasteStore) Paste(text string) (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	id := hex.EncodeToString(b)
+
+	if err := os.WriteFile(filepath.Join(ps.Dir, id+".txt"), []byte(text), 0o644); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s/pastes/%s", ps.BaseURL, id), nil
+}
+
+func (ps *LocalPasteStore) Get(id string) (string, error) {
+	if !pasteIdRegex.MatchString(id) {
+		return "", errors.New(invalidPasteIdErrMsg)
+	}
+
+	content, err := os.ReadFile(filepath.Join(ps.Dir, id+".txt"))
+	if err != nil {
+		return "", errors.New(pasteNotFoundErrMsg)
+	}
+
+	return string(content), nil
+}
+
+// overflowMsg limits msg to maxLines as it will be sent, counting each line
+// split to fit the line budget, and pastes the rest.
+func overflowMsg(msg string, maxLines, budget int, ps PasteStore) string {
+	if maxLines <= 0 {
+		return msg
+	}
+
+	lines := strings.Split(msg, "\n")
+	out := []string{}
+	sent, total := 0, 0
+
+	for _, line := range lines {
+		chunks := splitMsg(colourMsg(line), budget)
+		total += len(chunks)
+
+		switch room := maxLines - sent; {
+		case room <= 0:
+			continue
+		case len(chunks) <= room:
+			out = append(out, line)
+			sent += len(chunks)
+		default:
+			// the chunks are already coloured, so only their braces need
+			// escaping to be sent as they are
+			for _, c := range chunks[:room] {
+				out = append(out, message.EscapeTokens(c))
+			}
+			sent = maxLines
+		}
+	}
+
+	if total <= maxLines {
+		return msg
+	}
+
+	more := fmt.Sprintf("... %d more lines", total-maxLines)
+
+	if ps != nil {
+		url, err := ps.Paste(stripFormatting(colourMsg(msg)))
+		if err != nil {
+			log.Printf("Could not paste %d lines of output: %s", len(lines), err)
+		} else {
+			more += ": " + url
+		}
+	}
+
+	return strings.Join(append(out, more), "\n")
+}
+
+func createPasteHandler(ps *LocalPasteStore) func(*gin.Context) {
+	return func(c *gin.Context) {
+		text, err := ps.Get(c.Param("id"))
+		if err != nil {
+			c.IndentedJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(text))
+	}
+}
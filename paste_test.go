@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakePasteStore struct {
+	text string
+	err  error
+}
+
+func (ps *fakePasteStore) Paste(text string) (string, error) {
+	ps.text = text
+	return "https://paste.example.com/abc", ps.err
+}
+
+func TestOverflowMsg(t *testing.T) {
+	long := "{red}one{clear}\ntwo\nthree\nfour"
+
+	cases := map[string]struct {
+		msg      string
+		maxLines int
+		ps       PasteStore
+		expected string
+		pasted   string
+	}{
+		"no limit": {
+			msg:      long,
+			maxLines: 0,
+			ps:       &fakePasteStore{},
+			expected: long,
+		},
+		"within limit": {
+			msg:      long,
+			maxLines: 4,
+			ps:       &fakePasteStore{},
+			expected: long,
+		},
+		"pasted": {
+			msg:      long,
+			maxLines: 2,
+			ps:       &fakePasteStore{},
+			expected: "{red}one{clear}\ntwo\n... 2 more lines: https://paste.example.com/abc",
+			pasted:   "one\ntwo\nthree\nfour",
+		},
+		"no paste store": {
+			msg:      long,
+			maxLines: 3,
+			ps:       nil,
+			expected: "{red}one{clear}\ntwo\nthree\n... 1 more lines",
+		},
+		"long line within limit": {
+			msg:      strings.Repeat("word ", 20) + "\nnext",
+			maxLines: 4,
+			ps:       nil,
+			expected: strings.Repeat("word ", 20) + "\nnext",
+		},
+		"long line split": {
+			msg:      strings.Repeat("word ", 20) + "\nnext",
+			maxLines: 2,
+			ps:       nil,
+			expected: strings.Repeat("word ", 8) + "\n" + strings.Repeat("word ", 8) + "\n... 2 more lines",
+		},
+		"split line keeps formatting": {
+			msg:      "{red}" + strings.Repeat("{{x} ", 12),
+			maxLines: 1,
+			ps:       nil,
+			expected: "\x0304" + strings.Repeat("{{x} ", 7) + "{{x}\x0399\n... 1 more lines",
+		},
+		"paste failed": {
+			msg:      long,
+			maxLines: 1,
+			ps:       &fakePasteStore{err: errors.New("unavailable")},
+			expected: "{red}one{clear}\n... 3 more lines",
+			pasted:   "one\ntwo\nthree\nfour",
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, overflowMsg(tc.msg, tc.maxLines, 40, tc.ps))
+
+			if fps, ok := tc.ps.(*fakePasteStore); ok {
+				assert.Equal(t, tc.pasted, fps.text)
+			}
+		})
+	}
+}
+
+func TestLocalPasteStore(t *testing.T) {
+	ps, err := NewPasteStore(&Config{PasteBackend: pasteLocal, PasteDir: t.TempDir(), PasteURL: "https://gowon.example.com/"})
+	assert.Nil(t, err)
+
+	url, err := ps.Paste("one\ntwo")
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(url, "https://gowon.example.com/pastes/"))
+
+	lps := ps.(*LocalPasteStore)
+
+	text, err := lps.Get(strings.TrimPrefix(url, "https://gowon.example.com/pastes/"))
+	assert.Nil(t, err)
+	assert.Equal(t, "one\ntwo", text)
+
+	_, err = lps.Get("../../etc/passwd")
+	assert.EqualError(t, err, invalidPasteIdErrMsg)
+
+	_, err = lps.Get("0123456789abcdef")
+	assert.EqualError(t, err, pasteNotFoundErrMsg)
+}
+
+func TestNewPasteStoreNone(t *testing.T) {
+	ps, err := NewPasteStore(&Config{PasteBackend: pasteNone})
+
+	assert.Nil(t, err)
+	assert.Nil(t, ps)
+}
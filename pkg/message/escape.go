@@ -0,0 +1,9 @@
+package message
+
+import "strings"
+
+// EscapeTokens escapes text so that gowon sends it as is rather than
+// interpreting formatting tokens such as {red} in it.
+func EscapeTokens(text string) string {
+	return strings.ReplaceAll(text, "{", "{{")
+}
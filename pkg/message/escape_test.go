@@ -0,0 +1,42 @@
+package message
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEscapeTokens(t *testing.T) {
+	cases := []struct {
+		name     string
+		in       string
+		expected string
+	}{
+		{
+			name:     "No braces",
+			in:       "hello world",
+			expected: "hello world",
+		},
+		{
+			name:     "Token",
+			in:       "{red}hello",
+			expected: "{{red}hello",
+		},
+		{
+			name:     "JSON",
+			in:       `{"a": {"b": 1}}`,
+			expected: `{{"a": {{"b": 1}}`,
+		},
+		{
+			name:     "Already escaped",
+			in:       "{{red}",
+			expected: "{{{{red}",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, EscapeTokens(tc.in))
+		})
+	}
+}
@@ -13,10 +13,10 @@ const ErrorFilterInvertNotAtBeginning = "Inversion (!) can only exist at the beg
 
 const ErrorFilterInvalidFields = "Filter does not contain two fields, equals should appear once"
 
-const ErrorFilterInvalidKey = "Filter key is invalid, must be one of module, msg, nick, dest, command, args"
+const ErrorFilterInvalidKey = "Filter key is invalid, must be one of module, msg, nick, dest, command, args, network, code"
 
 func checkFilterSingle(filter string) error {
-	m, err := regexp.MatchString(`^[!=a-zA-Z0-9]+$`, filter)
+	m, err := regexp.MatchString(`^[!=a-zA-Z0-9#&_.-]+$`, filter)
 	if err != nil {
 		return err
 	}
@@ -46,7 +46,7 @@ func checkFilterSingle(filter string) error {
 	}
 
 	key := strings.TrimPrefix(strings.Split(filter, "=")[0], "!")
-	if !contains([]string{"module", "msg", "nick", "dest", "command", "args"}, key) {
+	if !contains([]string{"module", "msg", "nick", "dest", "command", "args", "network", "code"}, key) {
 		return errors.New(ErrorFilterInvalidKey)
 	}
 
@@ -87,6 +87,8 @@ func filterSingle(m *Message, filter string) (bool, error) {
 		"dest":    m.Dest,
 		"command": m.Command,
 		"args":    m.Args,
+		"network": m.Network,
+		"code":    m.Code,
 	}
 
 	invertFilter := strings.HasPrefix(filter, "!")
@@ -131,6 +131,22 @@ var filters = []struct {
 		filter: "module=othermod",
 		result: false,
 	},
+	{
+		name: "Filter channel",
+		message: Message{
+			Dest: "#gowon-dev",
+		},
+		filter: "dest=#gowon-dev",
+		result: true,
+	},
+	{
+		name: "Filter code",
+		message: Message{
+			Code: "JOIN",
+		},
+		filter: "code=JOIN",
+		result: true,
+	},
 	{
 		name: "False condition with inversion",
 		message: Message{
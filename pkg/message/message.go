@@ -8,12 +8,22 @@ import (
 )
 
 type Message struct {
-	Module  string `json:"module"`
-	Msg     string `json:"msg"`
-	Nick    string `json:"nick,omitempty"`
-	Dest    string `json:"dest"`
-	Command string `json:"command"`
-	Args    string `json:"args"`
+	Module    string            `json:"module"`
+	Msg       string            `json:"msg"`
+	Nick      string            `json:"nick,omitempty"`
+	Dest      string            `json:"dest"`
+	Command   string            `json:"command"`
+	Args      string            `json:"args"`
+	Network   string            `json:"network,omitempty"`
+	Code      string            `json:"code,omitempty"`
+	Raw       string            `json:"raw,omitempty"`
+	Host      string            `json:"host,omitempty"`
+	Source    string            `json:"source,omitempty"`
+	User      string            `json:"user,omitempty"`
+	Arguments []string          `json:"arguments,omitempty"`
+	Tags      map[string]string `json:"tags,omitempty"`
+	Plain     bool              `json:"plain,omitempty"`
+	Format    string            `json:"format,omitempty"`
 }
 
 const ErrorMessageParseMsg = "message couldn't be parsed as message json"
@@ -24,7 +34,11 @@ const ErrorMessageNoBodyMsg = "message body does not contain any message content
 
 const ErrorMessageNoDestinationMsg = "message body does not contain a destination"
 
-func getCommand(msg string) string {
+const ErrorMessageInvalidFormatMsg = "message format is not supported, must be empty or markdown"
+
+const FormatMarkdown = "markdown"
+
+func GetCommand(msg string) string {
 	if strings.HasPrefix(msg, ".") {
 		return strings.TrimPrefix(strings.Fields(msg)[0], ".")
 	}
@@ -32,7 +46,7 @@ func getCommand(msg string) string {
 	return ""
 }
 
-func getArgs(msg string) string {
+func GetArgs(msg string) string {
 	if !strings.HasPrefix(msg, ".") {
 		return msg
 	}
@@ -58,12 +72,16 @@ func CreateMessageStruct(body []byte) (m Message, err error) {
 		return m, errors.New(ErrorMessageNoDestinationMsg)
 	}
 
+	if m.Format != "" && m.Format != FormatMarkdown {
+		return m, errors.New(ErrorMessageInvalidFormatMsg)
+	}
+
 	if m.Command == "" {
-		m.Command = getCommand(m.Msg)
+		m.Command = GetCommand(m.Msg)
 	}
 
 	if m.Args == "" {
-		m.Args = getArgs(m.Msg)
+		m.Args = GetArgs(m.Msg)
 	}
 
 	return m, nil
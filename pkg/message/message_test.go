@@ -35,6 +35,11 @@ func TestCreateMessageStructErrors(t *testing.T) {
 			body:   []byte(`{"module": "test", "msg": "m"}`),
 			errMsg: ErrorMessageNoDestinationMsg,
 		},
+		{
+			name:   "Invalid format",
+			body:   []byte(`{"module": "test", "msg": "m", "dest": "d", "format": "html"}`),
+			errMsg: ErrorMessageInvalidFormatMsg,
+		},
 	}
 
 	for _, tc := range cases {
@@ -0,0 +1,5 @@
+// Package module contains the protobuf definition of the gowon module gRPC
+// service and the code generated from it.
+package module
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative module.proto
@@ -0,0 +1,265 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             v4.25.3
+// source: module.proto
+
+package module
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Module_Send_FullMethodName     = "/gowon.module.v1.Module/Send"
+	Module_Help_FullMethodName     = "/gowon.module.v1.Module/Help"
+	Module_Manifest_FullMethodName = "/gowon.module.v1.Module/Manifest"
+	Module_Events_FullMethodName   = "/gowon.module.v1.Module/Events"
+)
+
+// ModuleClient is the client API for Module service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ModuleClient interface {
+	// Send is called when a message matches one of the module's commands. An
+	// empty msg in the reply means there is nothing to send.
+	Send(ctx context.Context, in *Message, opts ...grpc.CallOption) (*Message, error)
+	// Help returns the help text for a command.
+	Help(ctx context.Context, in *HelpRequest, opts ...grpc.CallOption) (*HelpReply, error)
+	// Manifest describes the commands and events the module wants.
+	Manifest(ctx context.Context, in *ManifestRequest, opts ...grpc.CallOption) (*ManifestReply, error)
+	// Events streams the irc events listed in the manifest to the module.
+	Events(ctx context.Context, opts ...grpc.CallOption) (Module_EventsClient, error)
+}
+
+type moduleClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewModuleClient(cc grpc.ClientConnInterface) ModuleClient {
+	return &moduleClient{cc}
+}
+
+func (c *moduleClient) Send(ctx context.Context, in *Message, opts ...grpc.CallOption) (*Message, error) {
+	out := new(Message)
+	err := c.cc.Invoke(ctx, Module_Send_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *moduleClient) Help(ctx context.Context, in *HelpRequest, opts ...grpc.CallOption) (*HelpReply, error) {
+	out := new(HelpReply)
+	err := c.cc.Invoke(ctx, Module_Help_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *moduleClient) Manifest(ctx context.Context, in *ManifestRequest, opts ...grpc.CallOption) (*ManifestReply, error) {
+	out := new(ManifestReply)
+	err := c.cc.Invoke(ctx, Module_Manifest_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *moduleClient) Events(ctx context.Context, opts ...grpc.CallOption) (Module_EventsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Module_ServiceDesc.Streams[0], Module_Events_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &moduleEventsClient{stream}
+	return x, nil
+}
+
+type Module_EventsClient interface {
+	Send(*Message) error
+	CloseAndRecv() (*EventsReply, error)
+	grpc.ClientStream
+}
+
+type moduleEventsClient struct {
+	grpc.ClientStream
+}
+
+func (x *moduleEventsClient) Send(m *Message) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *moduleEventsClient) CloseAndRecv() (*EventsReply, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(EventsReply)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ModuleServer is the server API for Module service.
+// All implementations must embed UnimplementedModuleServer
+// for forward compatibility
+type ModuleServer interface {
+	// Send is called when a message matches one of the module's commands. An
+	// empty msg in the reply means there is nothing to send.
+	Send(context.Context, *Message) (*Message, error)
+	// Help returns the help text for a command.
+	Help(context.Context, *HelpRequest) (*HelpReply, error)
+	// Manifest describes the commands and events the module wants.
+	Manifest(context.Context, *ManifestRequest) (*ManifestReply, error)
+	// Events streams the irc events listed in the manifest to the module.
+	Events(Module_EventsServer) error
+	mustEmbedUnimplementedModuleServer()
+}
+
+// UnimplementedModuleServer must be embedded to have forward compatible implementations.
+type UnimplementedModuleServer struct {
+}
+
+func (UnimplementedModuleServer) Send(context.Context, *Message) (*Message, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Send not implemented")
+}
+func (UnimplementedModuleServer) Help(context.Context, *HelpRequest) (*HelpReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Help not implemented")
+}
+func (UnimplementedModuleServer) Manifest(context.Context, *ManifestRequest) (*ManifestReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Manifest not implemented")
+}
+func (UnimplementedModuleServer) Events(Module_EventsServer) error {
+	return status.Errorf(codes.Unimplemented, "method Events not implemented")
+}
+func (UnimplementedModuleServer) mustEmbedUnimplementedModuleServer() {}
+
+// UnsafeModuleServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ModuleServer will
+// result in compilation errors.
+type UnsafeModuleServer interface {
+	mustEmbedUnimplementedModuleServer()
+}
+
+func RegisterModuleServer(s grpc.ServiceRegistrar, srv ModuleServer) {
+	s.RegisterService(&Module_ServiceDesc, srv)
+}
+
+func _Module_Send_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Message)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ModuleServer).Send(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Module_Send_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ModuleServer).Send(ctx, req.(*Message))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Module_Help_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HelpRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ModuleServer).Help(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Module_Help_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ModuleServer).Help(ctx, req.(*HelpRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Module_Manifest_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ManifestRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ModuleServer).Manifest(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Module_Manifest_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ModuleServer).Manifest(ctx, req.(*ManifestRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Module_Events_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(ModuleServer).Events(&moduleEventsServer{stream})
+}
+
+type Module_EventsServer interface {
+	SendAndClose(*EventsReply) error
+	Recv() (*Message, error)
+	grpc.ServerStream
+}
+
+type moduleEventsServer struct {
+	grpc.ServerStream
+}
+
+func (x *moduleEventsServer) SendAndClose(m *EventsReply) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *moduleEventsServer) Recv() (*Message, error) {
+	m := new(Message)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Module_ServiceDesc is the grpc.ServiceDesc for Module service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Module_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "gowon.module.v1.Module",
+	HandlerType: (*ModuleServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Send",
+			Handler:    _Module_Send_Handler,
+		},
+		{
+			MethodName: "Help",
+			Handler:    _Module_Help_Handler,
+		},
+		{
+			MethodName: "Manifest",
+			Handler:    _Module_Manifest_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Events",
+			Handler:       _Module_Events_Handler,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "module.proto",
+}
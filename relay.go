@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/ergochat/irc-go/ircmsg"
+	"github.com/gowon-irc/gowon/pkg/message"
+	"golang.org/x/time/rate"
+)
+
+const (
+	relayQueueSize = 100
+)
+
+type Relay struct {
+	FromNetwork string `yaml:"from_network"`
+	From        string `validate:"required,irc_channel"`
+	ToNetwork   string `yaml:"to_network"`
+	To          string `validate:"required,irc_channel"`
+	Filter      string `validate:"omitempty,message_filter"`
+}
+
+func nickColour(nick string) string {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(strings.ToLower(nick)))
+
+	return listColours[h.Sum32()%uint32(len(listColours))]
+}
+
+func formatRelay(nick, network, text string, crossNetwork bool) string {
+	text = message.EscapeTokens(text)
+	name := message.EscapeTokens(nick)
+	if crossNetwork {
+		name = fmt.Sprintf("%s@%s", name, network)
+	}
+
+	coloured := fmt.Sprintf("{%s}%s{clear}", nickColour(nick), name)
+
+	if strings.HasPrefix(text, "\x01ACTION ") {
+		action := strings.TrimSuffix(strings.TrimPrefix(text, "\x01ACTION "), "\x01")
+		return fmt.Sprintf("* %s %s", coloured, action)
+	}
+
+	return fmt.Sprintf("<%s> %s", coloured, text)
+}
+
+type relayLine struct {
+	dest string
+	msg  string
+}
+
+type relayQueue struct {
+	limiter *rate.Limiter
+	lines   chan relayLine
+}
+
+type Relayer struct {
+	cm       *ConfigManager
+	networks Networks
+	mu       sync.Mutex
+	queues   map[string]*relayQueue
+}
+
+func NewRelayer(cm *ConfigManager, networks Networks) *Relayer {
+	return &Relayer{
+		cm:       cm,
+		networks: networks,
+		queues:   make(map[string]*relayQueue),
+	}
+}
+
+func (r *Relayer) networkName(name string) string {
+	n, err := r.networks.Get(name)
+	if err != nil {
+		return name
+	}
+
+	return n.Name
+}
+
+// ownNick reports whether nick is the bot on network, since someone else can
+// have the bot's nick on another network.
+func (r *Relayer) ownNick(network, nick string) bool {
+	n, err := r.networks.Get(network)
+	if err != nil {
+		return false
+	}
+
+	return strings.EqualFold(n.irccon.CurrentNick(), nick)
+}
+
+func (r *Relayer) queue(n *Network) *relayQueue {
+	cfg := r.cm.Config()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	q, ok := r.queues[n.Name]
+	if !ok {
+		q = &relayQueue{
+			limiter: rate.NewLimiter(rate.Limit(cfg.RelayRate), cfg.RelayBurst),
+			lines:   make(chan relayLine, relayQueueSize),
+		}
+		r.queues[n.Name] = q
+
+		go func() {
+			for l := range q.lines {
+				if err := q.limiter.Wait(context.Background()); err != nil {
+					log.Println(err)
+				}
+				n.SendMessage(l.dest, l.msg, nil)
+			}
+		}()
+	}
+
+	q.limiter.SetLimit(rate.Limit(cfg.RelayRate))
+	q.limiter.SetBurst(cfg.RelayBurst)
+
+	return q
+}
+
+type relayTarget struct {
+	network string
+	line    relayLine
+}
+
+func (r *Relayer) targets(m *message.Message, text string) []relayTarget {
+	out := []relayTarget{}
+
+	if r.ownNick(m.Network, m.Nick) {
+		return out
+	}
+
+	for _, relay := range r.cm.Config().Relays {
+		from := r.networkName(relay.FromNetwork)
+		to := r.networkName(relay.ToNetwork)
+
+		if from != m.Network || !strings.EqualFold(relay.From, m.Dest) {
+			continue
+		}
+
+		if from == to && strings.EqualFold(relay.From, relay.To) {
+			continue
+		}
+
+		if relay.Filter != "" {
+			ok, err := message.Filter(m, relay.Filter)
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+
+			if !ok {
+				continue
+			}
+		}
+
+		out = append(out, relayTarget{
+			network: to,
+			line: relayLine{
+				dest: relay.To,
+				msg:  formatRelay(m.Nick, m.Network, text, from != to),
+			},
+		})
+	}
+
+	return out
+}
+
+func (r *Relayer) Relay(m *message.Message, text string) {
+	for _, t := range r.targets(m, text) {
+		n, err := r.networks.Get(t.network)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+
+		select {
+		case r.queue(n).lines <- t.line:
+		default:
+			log.Printf("Relay queue for %s is full, dropping message to %s", n.Name, t.line.dest)
+		}
+	}
+}
+
+func createRelayHandler(n *Network, r *Relayer) func(event ircmsg.Message) {
+	return func(event ircmsg.Message) {
+		if len(event.Params) < 2 {
+			return
+		}
+
+		r.Relay(createMessage(n, event), event.Params[1])
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ergochat/irc-go/ircevent"
+	"github.com/gowon-irc/gowon/pkg/message"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNickColour(t *testing.T) {
+	assert.Equal(t, nickColour("gowon"), nickColour("Gowon"))
+	assert.Contains(t, listColours, nickColour("gowon"))
+}
+
+func TestFormatRelay(t *testing.T) {
+	c := nickColour("nick")
+
+	cases := map[string]struct {
+		text         string
+		crossNetwork bool
+		expected     string
+	}{
+		"message": {
+			text:     "hello",
+			expected: "<{" + c + "}nick{clear}> hello",
+		},
+		"cross network message": {
+			text:         "hello",
+			crossNetwork: true,
+			expected:     "<{" + c + "}nick@libera{clear}> hello",
+		},
+		"action": {
+			text:     "\x01ACTION waves\x01",
+			expected: "* {" + c + "}nick{clear} waves",
+		},
+		"tokens escaped": {
+			text:     "{red}hello",
+			expected: "<{" + c + "}nick{clear}> {{red}hello",
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, formatRelay("nick", "libera", tc.text, tc.crossNetwork))
+		})
+	}
+}
+
+func TestRelayerTargets(t *testing.T) {
+	networks := Networks{
+		{Name: defaultNetwork, irccon: &ircevent.Connection{}},
+		{Name: "libera", irccon: &ircevent.Connection{}},
+	}
+
+	cm := NewConfigManager()
+	cm.MergedConfig = &Config{
+		Relays: []Relay{
+			{From: "#team", To: "#team-archive"},
+			{From: "#team", ToNetwork: "libera", To: "#team", Filter: "!nick=spammer"},
+			{FromNetwork: "libera", From: "#team", To: "#team"},
+			{From: "#loop", To: "#loop"},
+		},
+	}
+
+	r := NewRelayer(cm, networks)
+
+	cases := map[string]struct {
+		m        message.Message
+		expected []relayTarget
+	}{
+		"relayed to channel and network": {
+			m: message.Message{Network: defaultNetwork, Dest: "#team", Nick: "nick"},
+			expected: []relayTarget{
+				{network: defaultNetwork, line: relayLine{dest: "#team-archive", msg: formatRelay("nick", defaultNetwork, "hi", false)}},
+				{network: "libera", line: relayLine{dest: "#team", msg: formatRelay("nick", defaultNetwork, "hi", true)}},
+			},
+		},
+		"filtered": {
+			m: message.Message{Network: defaultNetwork, Dest: "#team", Nick: "spammer"},
+			expected: []relayTarget{
+				{network: defaultNetwork, line: relayLine{dest: "#team-archive", msg: formatRelay("spammer", defaultNetwork, "hi", false)}},
+			},
+		},
+		"other network": {
+			m: message.Message{Network: "libera", Dest: "#team", Nick: "nick"},
+			expected: []relayTarget{
+				{network: defaultNetwork, line: relayLine{dest: "#team", msg: formatRelay("nick", "libera", "hi", true)}},
+			},
+		},
+		"relay to itself": {
+			m:        message.Message{Network: defaultNetwork, Dest: "#loop", Nick: "nick"},
+			expected: []relayTarget{},
+		},
+		"unrelayed channel": {
+			m:        message.Message{Network: defaultNetwork, Dest: "#other", Nick: "nick"},
+			expected: []relayTarget{},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, r.targets(&tc.m, "hi"))
+		})
+	}
+}
+
+func TestRelayerOwnNickPerNetwork(t *testing.T) {
+	s := newTestIrcServer(t, "")
+	n := newTestNetwork(t, s, &Config{})
+	connectTestNetwork(t, n)
+	assert.Eventually(t, func() bool {
+		return n.irccon.CurrentNick() == "gowon"
+	}, 5*time.Second, 10*time.Millisecond)
+
+	networks := Networks{n, {Name: "libera", irccon: &ircevent.Connection{}}}
+
+	cm := NewConfigManager()
+	cm.MergedConfig = &Config{
+		Relays: []Relay{
+			{From: "#team", ToNetwork: "libera", To: "#team"},
+			{FromNetwork: "libera", From: "#team", To: "#team"},
+		},
+	}
+
+	r := NewRelayer(cm, networks)
+
+	// the bot's own lines aren't relayed back
+	assert.Empty(t, r.targets(&message.Message{Network: defaultNetwork, Dest: "#team", Nick: "gowon"}, "hi"))
+
+	// but someone else called gowon on another network is
+	assert.Len(t, r.targets(&message.Message{Network: "libera", Dest: "#team", Nick: "gowon"}, "hi"), 1)
+}
@@ -1,23 +1,67 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
+	"net"
+	"net/url"
 	"regexp"
 	"sort"
 	"strings"
+	"sync"
 
-	"github.com/gowon-irc/go-gowon"
+	"github.com/gowon-irc/gowon/pkg/message"
 	"github.com/imroc/req/v3"
 )
 
 const (
 	noCommandRoutedErrMsg = "no command could be routed"
+
+	unixScheme = "unix"
+	// requests over a unix socket still need a host in their url
+	unixBaseURL = "http://unix"
+)
+
+var (
+	httpClient  = req.C()
+	unixClients sync.Map
 )
 
+func unixSocketPath(endpoint string) (string, bool) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Scheme != unixScheme || u.Host != "" || !strings.HasPrefix(u.Path, "/") {
+		return "", false
+	}
+
+	return u.Path, true
+}
+
+// endpointClient returns the client and base url to use for an endpoint,
+// dialling the socket for unix:///path/to.sock endpoints.
+func endpointClient(endpoint string) (*req.Client, string) {
+	path, ok := unixSocketPath(endpoint)
+	if !ok {
+		return httpClient, endpoint
+	}
+
+	if c, ok := unixClients.Load(path); ok {
+		return c.(*req.Client), unixBaseURL
+	}
+
+	c := req.C().SetDial(func(ctx context.Context, _, _ string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, unixScheme, path)
+	})
+
+	actual, _ := unixClients.LoadOrStore(path, c)
+
+	return actual.(*req.Client), unixBaseURL
+}
+
 type RouterCommand interface {
-	Send(in *gowon.Message) *gowon.Message
+	Send(in *message.Message) *message.Message
 	GetHelp() string
 	GetCommand() string
 	GetPriority() int
@@ -32,15 +76,15 @@ type HttpCommand struct {
 	Priority int
 }
 
-func (hc *HttpCommand) Send(in *gowon.Message) *gowon.Message {
-	var out gowon.Message
+func (hc *HttpCommand) Send(in *message.Message) *message.Message {
+	var out message.Message
 
-	client := req.C()
+	client, base := endpointClient(hc.Endpoint)
 	resp, err := client.R().
 		SetBody(in).
 		SetSuccessResult(&out).
 		SetErrorResult(&out).
-		Post(hc.Endpoint + "/message")
+		Post(base + "/message")
 
 	if err != nil {
 		log.Println(err)
@@ -69,12 +113,12 @@ func (hc *HttpCommand) GetHelp() string {
 		return fmt.Sprintf("{cyan}%s{clear}: %s", hc.Command, hc.Help)
 	}
 
-	var msg gowon.Message
+	var msg message.Message
 
-	client := req.C()
+	client, base := endpointClient(hc.Endpoint)
 	resp, err := client.R().
 		SetSuccessResult(&msg).
-		Get(hc.Endpoint + "/help")
+		Get(base + "/help")
 
 	if err != nil {
 		log.Println(err)
@@ -98,7 +142,7 @@ func (hc *HttpCommand) GetPriority() int {
 }
 
 func (hc *HttpCommand) Match(text string) bool {
-	if hc.Command == gowon.GetCommand(text) {
+	if hc.Command == message.GetCommand(text) {
 		return true
 	}
 
@@ -114,19 +158,20 @@ type InternalCommand struct {
 	Command  string
 	Help     string
 	Priority int
-	f        func(in *gowon.Message) string
+	f        func(in *message.Message) string
 }
 
-func (ic *InternalCommand) Send(in *gowon.Message) (out *gowon.Message) {
+func (ic *InternalCommand) Send(in *message.Message) (out *message.Message) {
 	msg := ic.f(in)
 
-	return &gowon.Message{
+	return &message.Message{
 		Module:  ic.Command,
 		Msg:     msg,
 		Nick:    in.Nick,
 		Dest:    in.Dest,
 		Command: ic.Command,
 		Args:    in.Args,
+		Network: in.Network,
 	}
 }
 
@@ -147,10 +192,11 @@ func (ic *InternalCommand) GetPriority() int {
 }
 
 func (ic *InternalCommand) Match(text string) bool {
-	return ic.Command == gowon.GetCommand(text)
+	return ic.Command == message.GetCommand(text)
 }
 
 type CommandRouter struct {
+	mu       sync.RWMutex
 	Commands []RouterCommand
 }
 
@@ -163,10 +209,17 @@ func (cr *CommandRouter) Add(cmd *Command) {
 		Priority: cmd.Priority,
 	}
 
-	cr.Commands = append(cr.Commands, new)
+	cr.AddRouterCommand(new)
 }
 
-func (cr *CommandRouter) AddInternal(command, help string, f func(in *gowon.Message) string) {
+func (cr *CommandRouter) AddRouterCommand(rc RouterCommand) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	cr.Commands = append(cr.Commands, rc)
+}
+
+func (cr *CommandRouter) AddInternal(command, help string, f func(in *message.Message) string) {
 	new := &InternalCommand{
 		Command:  command,
 		Help:     help,
@@ -174,16 +227,22 @@ func (cr *CommandRouter) AddInternal(command, help string, f func(in *gowon.Mess
 		f:        f,
 	}
 
-	cr.Commands = append(cr.Commands, new)
+	cr.AddRouterCommand(new)
 }
 
 func (cr *CommandRouter) SortPriority() {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
 	sort.Slice(cr.Commands, func(i, j int) bool {
 		return cr.Commands[i].GetPriority() < cr.Commands[j].GetPriority()
 	})
 }
 
 func (cr *CommandRouter) Names() []string {
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+
 	out := []string{}
 
 	for _, c := range cr.Commands {
@@ -197,8 +256,26 @@ func (cr *CommandRouter) Names() []string {
 	return out
 }
 
+// scopedCommand is a command that may only be sent some messages, such as
+// one registered by a module whose api token is scoped.
+type scopedCommand interface {
+	Allows(in *message.Message) bool
+}
+
 func (cr *CommandRouter) Route(text string) (RouterCommand, error) {
+	return cr.RouteMessage(text, nil)
+}
+
+// RouteMessage routes text, skipping commands that aren't allowed to see in.
+func (cr *CommandRouter) RouteMessage(text string, in *message.Message) (RouterCommand, error) {
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+
 	for _, cmd := range cr.Commands {
+		if sc, ok := cmd.(scopedCommand); ok && in != nil && !sc.Allows(in) {
+			continue
+		}
+
 		if cmd.Match(text) {
 			return cmd, nil
 		}
@@ -207,18 +284,31 @@ func (cr *CommandRouter) Route(text string) (RouterCommand, error) {
 	return nil, errors.New(noCommandRoutedErrMsg)
 }
 
+// Replace swaps in a complete, sorted command list, so Route never sees one
+// that is half built.
+func (cr *CommandRouter) Replace(commands []RouterCommand) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	cr.Commands = commands
+}
+
 func (cr *CommandRouter) Clear() {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
 	cr.Commands = nil
 }
 
+var listColours = []string{"green", "red", "blue", "orange", "magenta", "cyan", "yellow"}
+
 func colourList(in []string) (out []string) {
 	out = []string{}
 
-	colours := []string{"green", "red", "blue", "orange", "magenta", "cyan", "yellow"}
-	cl := len(colours)
+	cl := len(listColours)
 
 	for n, i := range in {
-		c := colours[n%cl]
+		c := listColours[n%cl]
 		o := fmt.Sprintf("{%s}%s{clear}", c, i)
 		out = append(out, o)
 	}
@@ -226,14 +316,14 @@ func colourList(in []string) (out []string) {
 	return out
 }
 
-func createHelpCommandFunc(cr *CommandRouter) func(in *gowon.Message) string {
-	return func(in *gowon.Message) string {
+func createHelpCommandFunc(cr *CommandRouter) func(in *message.Message) string {
+	return func(in *message.Message) string {
 		if in.Args != "" {
 			cmd := strings.Fields(in.Args)[0]
 
 			command, err := cr.Route("." + cmd)
 			if err != nil {
-				return fmt.Sprintf("{cyan}%s{clear}: command not found", cmd)
+				return fmt.Sprintf("{cyan}%s{clear}: command not found", message.EscapeTokens(cmd))
 			}
 
 			return command.GetHelp()
@@ -1,9 +1,14 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
 	"testing"
 
+	"github.com/gowon-irc/gowon/pkg/message"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -226,3 +231,86 @@ func TestCommandRouterClear(t *testing.T) {
 		})
 	}
 }
+
+func TestCommandRouterReplace(t *testing.T) {
+	cr := &CommandRouter{}
+	cr.Add(&Command{Command: "old"})
+
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+
+		for i := 0; i < 100; i++ {
+			assert.Len(t, cr.Names(), 1)
+		}
+	}()
+
+	cr.Replace([]RouterCommand{&HttpCommand{Command: "echo"}})
+	<-done
+
+	_, err := cr.Route(".echo")
+	assert.Nil(t, err)
+
+	_, err = cr.Route(".old")
+	assert.EqualError(t, err, noCommandRoutedErrMsg)
+}
+
+func TestUnixSocketPath(t *testing.T) {
+	cases := map[string]struct {
+		endpoint string
+		path     string
+		ok       bool
+	}{
+		"socket": {
+			endpoint: "unix:///run/gowon/echo.sock",
+			path:     "/run/gowon/echo.sock",
+			ok:       true,
+		},
+		"http": {
+			endpoint: "http://echo:8080",
+		},
+		"relative path": {
+			endpoint: "unix://echo.sock",
+		},
+		"no path": {
+			endpoint: "unix://",
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			path, ok := unixSocketPath(tc.endpoint)
+			assert.Equal(t, tc.ok, ok)
+			assert.Equal(t, tc.path, path)
+		})
+	}
+}
+
+func TestHttpCommandUnixSocket(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "echo.sock")
+
+	ln, err := net.Listen("unix", sock)
+	assert.Nil(t, err)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/message", func(w http.ResponseWriter, r *http.Request) {
+		var in message.Message
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&in))
+		assert.Nil(t, json.NewEncoder(w).Encode(message.Message{Module: "echo", Msg: in.Args, Dest: in.Dest}))
+	})
+	mux.HandleFunc("/help", func(w http.ResponseWriter, r *http.Request) {
+		assert.Nil(t, json.NewEncoder(w).Encode(message.Message{Module: "echo", Msg: "repeats things"}))
+	})
+
+	srv := &http.Server{Handler: mux}
+	go func() {
+		_ = srv.Serve(ln)
+	}()
+	defer srv.Close()
+
+	hc := &HttpCommand{Command: "echo", Endpoint: "unix://" + sock}
+
+	out := hc.Send(&message.Message{Msg: ".echo hi", Dest: "#gowon", Command: "echo", Args: "hi"})
+	assert.Equal(t, "hi", out.Msg)
+	assert.Equal(t, "{cyan}echo{clear}: repeats things", hc.GetHelp())
+}
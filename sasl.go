@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/ergochat/irc-go/ircevent"
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+const (
+	saslPlain    = "PLAIN"
+	saslExternal = "EXTERNAL"
+
+	saslUnavailableErrMsg = "server does not support SASL"
+	saslFailedErrMsg      = "SASL EXTERNAL authentication failed"
+)
+
+func createTLSConfig(cfg NetworkConfig) (*tls.Config, error) {
+	tc := &tls.Config{
+		ServerName: strings.Split(cfg.Server, ":")[0],
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if cfg.TLSCert != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey)
+		if err != nil {
+			return nil, err
+		}
+
+		tc.Certificates = []tls.Certificate{cert}
+	}
+
+	return tc, nil
+}
+
+func saslAccount(cfg NetworkConfig) string {
+	if cfg.SASLAccount != "" {
+		return cfg.SASLAccount
+	}
+
+	return cfg.Nick
+}
+
+// bufferedConn hands anything read past the SASL exchange on to ircevent.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func writeLine(conn net.Conn, params ...string) error {
+	m := ircmsg.MakeMessage(nil, "", params[0], params[1:]...)
+
+	line, err := m.LineBytesStrict(true, defaultMaxLineLength)
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.Write(line)
+	return err
+}
+
+// authenticateExternal runs the SASL EXTERNAL exchange before ircevent sees
+// the connection, as ircevent only implements PLAIN. ircevent's own
+// capability negotiation then carries on and ends it with CAP END.
+func authenticateExternal(ctx context.Context, conn net.Conn, authzid string) (net.Conn, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := writeLine(conn, "CAP", "REQ", "sasl"); err != nil {
+		return nil, err
+	}
+
+	payload := "+"
+	if authzid != "" {
+		payload = base64.StdEncoding.EncodeToString([]byte(authzid))
+	}
+
+	r := bufio.NewReader(conn)
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		m, err := ircmsg.ParseLine(line)
+		if err != nil {
+			continue
+		}
+
+		switch {
+		case m.Command == "PING":
+			err = writeLine(conn, append([]string{"PONG"}, m.Params...)...)
+		case m.Command == "CAP" && len(m.Params) >= 3 && m.Params[1] == "ACK":
+			err = writeLine(conn, "AUTHENTICATE", saslExternal)
+		case m.Command == "CAP" && len(m.Params) >= 3 && m.Params[1] == "NAK":
+			return nil, errors.New(saslUnavailableErrMsg)
+		case m.Command == "AUTHENTICATE" && len(m.Params) > 0 && m.Params[0] == "+":
+			err = writeLine(conn, "AUTHENTICATE", payload)
+		case m.Command == ircevent.RPL_SASLSUCCESS:
+			if err := conn.SetDeadline(time.Time{}); err != nil {
+				return nil, err
+			}
+
+			return &bufferedConn{Conn: conn, r: r}, nil
+		case m.Command == ircevent.ERR_NICKLOCKED, m.Command == ircevent.ERR_SASLFAIL,
+			m.Command == ircevent.ERR_SASLTOOLONG, m.Command == ircevent.ERR_SASLABORTED,
+			m.Command == ircevent.ERR_SASLALREADY:
+			return nil, fmt.Errorf("%s: %s", saslFailedErrMsg, m.Params[len(m.Params)-1])
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// The connection has to be dialled with TLS here rather than by ircevent so
+// that the SASL exchange can be run over it first.
+func createSASLExternalDialer(tc *tls.Config, authzid string) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		d := &tls.Dialer{Config: tc}
+
+		conn, err := d.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		authed, err := authenticateExternal(ctx, conn, authzid)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		return authed, nil
+	}
+}
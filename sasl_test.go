@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ergochat/irc-go/ircmsg"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	testTLSDir = filepath.Join("testdata", "tls")
+)
+
+func TestCreateTLSConfig(t *testing.T) {
+	cases := map[string]struct {
+		cert  string
+		key   string
+		certs int
+		err   bool
+	}{
+		"no client certificate": {
+			certs: 0,
+			err:   false,
+		},
+		"client certificate": {
+			cert:  filepath.Join(testTLSDir, "client.crt"),
+			key:   filepath.Join(testTLSDir, "client.key"),
+			certs: 1,
+			err:   false,
+		},
+		"missing key": {
+			cert: filepath.Join(testTLSDir, "client.crt"),
+			key:  filepath.Join(testTLSDir, "nonexistent.key"),
+			err:  true,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			cfg := NetworkConfig{
+				Server:  "irc.libera.chat:6697",
+				TLSCert: tc.cert,
+				TLSKey:  tc.key,
+			}
+
+			out, err := createTLSConfig(cfg)
+
+			if tc.err {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.Nil(t, err)
+			assert.Equal(t, "irc.libera.chat", out.ServerName)
+			assert.Len(t, out.Certificates, tc.certs)
+		})
+	}
+}
+
+func TestSaslAccount(t *testing.T) {
+	assert.Equal(t, "gowon", saslAccount(NetworkConfig{Nick: "gowon"}))
+	assert.Equal(t, "account", saslAccount(NetworkConfig{Nick: "gowon", SASLAccount: "account"}))
+}
+
+func TestAuthenticateExternal(t *testing.T) {
+	cases := map[string]struct {
+		authzid string
+		replies map[string]string
+		payload string
+		errMsg  string
+	}{
+		"without authzid": {
+			replies: map[string]string{
+				"CAP REQ sasl":          ":irc.test CAP * ACK :sasl",
+				"AUTHENTICATE EXTERNAL": "AUTHENTICATE +",
+				"AUTHENTICATE +":        ":irc.test 903 * :SASL authentication successful\r\n:irc.test CAP * LS :sasl",
+			},
+			payload: "+",
+		},
+		"with authzid": {
+			authzid: "gowon",
+			replies: map[string]string{
+				"CAP REQ sasl":          ":irc.test NOTICE * :Looking up your hostname\r\n:irc.test CAP * ACK :sasl",
+				"AUTHENTICATE EXTERNAL": "PING :irc.test\r\nAUTHENTICATE +",
+				"AUTHENTICATE Z293b24=": ":irc.test 903 * :SASL authentication successful\r\n:irc.test CAP * LS :sasl",
+			},
+			payload: "Z293b24=",
+		},
+		"not supported": {
+			replies: map[string]string{
+				"CAP REQ sasl": ":irc.test CAP * NAK :sasl",
+			},
+			errMsg: saslUnavailableErrMsg,
+		},
+		"rejected": {
+			replies: map[string]string{
+				"CAP REQ sasl":          ":irc.test CAP * ACK :sasl",
+				"AUTHENTICATE EXTERNAL": "AUTHENTICATE +",
+				"AUTHENTICATE +":        ":irc.test 904 * :SASL authentication failed",
+			},
+			errMsg: saslFailedErrMsg,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			defer server.Close()
+
+			sent := make(chan string, 10)
+
+			go func() {
+				scanner := bufio.NewScanner(server)
+				for scanner.Scan() {
+					m, _ := ircmsg.ParseLine(scanner.Text())
+					key := strings.Join(append([]string{m.Command}, m.Params...), " ")
+					sent <- key
+
+					if r, ok := tc.replies[key]; ok {
+						_, _ = server.Write([]byte(r + "\r\n"))
+					}
+				}
+			}()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			conn, err := authenticateExternal(ctx, client, tc.authzid)
+			if tc.errMsg != "" {
+				assert.ErrorContains(t, err, tc.errMsg)
+				return
+			}
+
+			assert.Nil(t, err)
+
+			// anything the server sent after success is left for ircevent
+			line, err := bufio.NewReader(conn).ReadString('\n')
+			assert.Nil(t, err)
+			assert.Equal(t, ":irc.test CAP * LS :sasl\r\n", line)
+
+			got := []string{}
+			for len(sent) > 0 {
+				got = append(got, <-sent)
+			}
+			assert.Contains(t, got, "AUTHENTICATE "+tc.payload)
+		})
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ergochat/irc-go/ircevent"
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+const (
+	stateDisconnected = "disconnected"
+	stateConnecting   = "connecting"
+	stateConnected    = "connected"
+
+	lagPingPrefix = "gowon-lag-"
+
+	// The dialer does the waiting between attempts, so ircevent's own delay
+	// is kept negligible. It has to be non-zero or ircevent picks its own.
+	ircReconnectFreq = time.Millisecond
+)
+
+func backoff(attempt int, min, max time.Duration, jitter float64) time.Duration {
+	d := min
+	for i := 0; i < attempt && d < max; i++ {
+		d *= 2
+	}
+
+	if d > max {
+		d = max
+	}
+
+	return d/2 + time.Duration(jitter*float64(d/2))
+}
+
+type ConnectionStatus struct {
+	Network   string    `json:"network"`
+	State     string    `json:"state"`
+	Since     time.Time `json:"since"`
+	Server    string    `json:"server"`
+	Nick      string    `json:"nick"`
+	Attempts  int       `json:"attempts"`
+	LagMs     int64     `json:"lag_ms"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+type Supervisor struct {
+	network      string
+	irccon       *ircevent.Connection
+	minBackoff   time.Duration
+	maxBackoff   time.Duration
+	lagInterval  time.Duration
+	maxLag       time.Duration
+	mu           sync.Mutex
+	state        string
+	since        time.Time
+	attempts     int
+	lag          time.Duration
+	lastPingSent time.Time
+	lastError    error
+	dialled      bool
+}
+
+func NewSupervisor(network string, irccon *ircevent.Connection, cfg *Config) *Supervisor {
+	s := &Supervisor{
+		network:     network,
+		irccon:      irccon,
+		minBackoff:  cfg.ReconnectMin,
+		maxBackoff:  cfg.ReconnectMax,
+		lagInterval: cfg.LagInterval,
+		maxLag:      cfg.MaxLag,
+		state:       stateDisconnected,
+		since:       time.Now(),
+	}
+
+	if s.minBackoff <= 0 {
+		s.minBackoff = time.Second
+	}
+
+	if s.maxBackoff < s.minBackoff {
+		s.maxBackoff = s.minBackoff
+	}
+
+	dial := irccon.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+
+	// ircevent's dial deadline would run out while backing off, so the TLS
+	// handshake is done here with a fresh one.
+	tc := irccon.TLSConfig
+	useTLS := irccon.UseTLS
+	irccon.UseTLS = false
+	irccon.ReconnectFreq = ircReconnectFreq
+
+	irccon.DialContext = func(_ context.Context, network, addr string) (net.Conn, error) {
+		s.wait()
+		s.setState(stateConnecting, nil)
+
+		ctx, cancel := context.WithTimeout(context.Background(), irccon.Timeout)
+		defer cancel()
+
+		conn, err := dial(ctx, network, addr)
+		if err == nil && useTLS {
+			conn, err = tlsHandshake(ctx, conn, tc, addr)
+		}
+
+		if err != nil {
+			s.setState(stateDisconnected, err)
+			return nil, err
+		}
+
+		return conn, nil
+	}
+
+	irccon.AddConnectCallback(func(e ircmsg.Message) {
+		s.mu.Lock()
+		s.attempts = 0
+		s.lag = 0
+		s.lastPingSent = time.Time{}
+		s.mu.Unlock()
+
+		s.setState(stateConnected, nil)
+	})
+
+	irccon.AddDisconnectCallback(func(e ircmsg.Message) {
+		s.setState(stateDisconnected, nil)
+	})
+
+	irccon.AddCallback("PONG", s.handlePong)
+
+	return s
+}
+
+func tlsHandshake(ctx context.Context, conn net.Conn, tc *tls.Config, addr string) (net.Conn, error) {
+	if tc == nil {
+		host, _, _ := net.SplitHostPort(addr)
+		tc = &tls.Config{ServerName: host}
+	}
+
+	tlsConn := tls.Client(conn, tc)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return tlsConn, nil
+}
+
+func (s *Supervisor) nextBackoff() time.Duration {
+	return backoff(s.attempts, s.minBackoff, s.maxBackoff, rand.Float64())
+}
+
+// wait backs off before every dial but the first, for longer the more
+// attempts have failed since the last successful connection.
+func (s *Supervisor) wait() {
+	s.mu.Lock()
+	var delay time.Duration
+	if s.dialled {
+		delay = s.nextBackoff()
+	}
+	s.dialled = true
+	s.attempts++
+	s.mu.Unlock()
+
+	if delay > 0 {
+		log.Printf("Reconnecting to %s in %v", s.irccon.Server, delay.Round(time.Millisecond))
+		time.Sleep(delay)
+	}
+}
+
+func (s *Supervisor) setState(state string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err != nil {
+		s.lastError = err
+	}
+
+	if s.state == state {
+		return
+	}
+
+	if err != nil {
+		log.Printf("Connection state (%s): %s -> %s (%s)", s.network, s.state, state, err)
+	} else {
+		log.Printf("Connection state (%s): %s -> %s", s.network, s.state, state)
+	}
+
+	s.state = state
+	s.since = time.Now()
+}
+
+func (s *Supervisor) Status() ConnectionStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := ConnectionStatus{
+		Network:  s.network,
+		State:    s.state,
+		Since:    s.since,
+		Server:   s.irccon.Server,
+		Nick:     s.irccon.CurrentNick(),
+		Attempts: s.attempts,
+		LagMs:    s.lag.Milliseconds(),
+	}
+
+	if s.lastError != nil {
+		status.LastError = s.lastError.Error()
+	}
+
+	return status
+}
+
+func (s *Supervisor) handlePong(e ircmsg.Message) {
+	if len(e.Params) == 0 {
+		return
+	}
+
+	ts := strings.TrimPrefix(e.Params[len(e.Params)-1], lagPingPrefix)
+	sent, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.lag = time.Since(time.Unix(0, sent))
+	s.lastPingSent = time.Time{}
+	s.mu.Unlock()
+}
+
+func (s *Supervisor) checkLag() {
+	s.mu.Lock()
+	state := s.state
+	lag := s.lag
+	if !s.lastPingSent.IsZero() {
+		lag = time.Since(s.lastPingSent)
+	}
+	s.mu.Unlock()
+
+	if state != stateConnected {
+		return
+	}
+
+	if s.maxLag > 0 && lag > s.maxLag {
+		s.setState(stateDisconnected, fmt.Errorf("lag of %v exceeds %v", lag.Round(time.Millisecond), s.maxLag))
+		s.irccon.Reconnect()
+		return
+	}
+
+	now := time.Now()
+
+	s.mu.Lock()
+	if s.lastPingSent.IsZero() {
+		s.lastPingSent = now
+	}
+	s.mu.Unlock()
+
+	if err := s.irccon.Send("PING", fmt.Sprintf("%s%d", lagPingPrefix, now.UnixNano())); err != nil {
+		log.Println(err)
+	}
+}
+
+func (s *Supervisor) lagLoop() {
+	if s.lagInterval <= 0 {
+		return
+	}
+
+	for range time.Tick(s.lagInterval) {
+		s.checkLag()
+	}
+}
+
+// Run connects and then leaves ircevent to notice disconnections, clean up
+// and call Connect again, which backs off in the dialer.
+func (s *Supervisor) Run() {
+	for {
+		s.mu.Lock()
+		attempts := s.attempts
+		s.mu.Unlock()
+
+		err := s.irccon.Connect()
+		if err == nil {
+			break
+		}
+
+		s.setState(stateDisconnected, err)
+
+		// nothing was dialled, so retrying would fail the same way
+		s.mu.Lock()
+		dialled := s.attempts != attempts
+		s.mu.Unlock()
+
+		if !dialled {
+			log.Printf("Could not connect to %s: %s", s.irccon.Server, err)
+			return
+		}
+	}
+
+	go s.lagLoop()
+
+	s.irccon.Loop()
+}
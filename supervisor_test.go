@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ergochat/irc-go/ircevent"
+	"github.com/ergochat/irc-go/ircmsg"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoff(t *testing.T) {
+	cases := map[string]struct {
+		attempt  int
+		jitter   float64
+		expected time.Duration
+	}{
+		"first attempt, no jitter": {
+			attempt:  0,
+			jitter:   0,
+			expected: 500 * time.Millisecond,
+		},
+		"first attempt, full jitter": {
+			attempt:  0,
+			jitter:   1,
+			expected: time.Second,
+		},
+		"third attempt": {
+			attempt:  3,
+			jitter:   1,
+			expected: 8 * time.Second,
+		},
+		"capped at maximum": {
+			attempt:  10,
+			jitter:   1,
+			expected: time.Minute,
+		},
+		"capped at maximum, half jitter": {
+			attempt:  100,
+			jitter:   0.5,
+			expected: 45 * time.Second,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, backoff(tc.attempt, time.Second, time.Minute, tc.jitter))
+		})
+	}
+}
+
+func TestNewSupervisorDefaults(t *testing.T) {
+	s := NewSupervisor(defaultNetwork, &ircevent.Connection{}, &Config{})
+
+	assert.Equal(t, time.Second, s.minBackoff)
+	assert.Equal(t, time.Second, s.maxBackoff)
+	assert.Equal(t, stateDisconnected, s.Status().State)
+}
+
+func TestSupervisorHandlePong(t *testing.T) {
+	s := NewSupervisor(defaultNetwork, &ircevent.Connection{}, &Config{})
+	s.lastPingSent = time.Now()
+
+	s.handlePong(ircmsg.MakeMessage(nil, "irc.server", "PONG", "irc.server", "other"))
+	assert.False(t, s.lastPingSent.IsZero())
+
+	sent := time.Now().Add(-2 * time.Second)
+	s.handlePong(ircmsg.MakeMessage(nil, "irc.server", "PONG", "irc.server", fmt.Sprintf("%s%d", lagPingPrefix, sent.UnixNano())))
+
+	assert.True(t, s.lastPingSent.IsZero())
+	assert.GreaterOrEqual(t, s.Status().LagMs, int64(2000))
+}
+
+func TestSupervisorReconnects(t *testing.T) {
+	s := newTestIrcServer(t, "")
+	n := newTestNetwork(t, s, &Config{ReconnectMin: 10 * time.Millisecond, ReconnectMax: 50 * time.Millisecond})
+
+	done := make(chan struct{})
+	go func() {
+		n.supervisor.Run()
+		close(done)
+	}()
+
+	connected := func() bool {
+		return n.supervisor.Status().State == stateConnected
+	}
+
+	s.expect(t, "USER")
+	assert.Eventually(t, connected, 5*time.Second, 10*time.Millisecond)
+
+	s.drop()
+	s.expect(t, "USER")
+	assert.Eventually(t, connected, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 0, n.supervisor.Status().Attempts)
+
+	n.irccon.Quit()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("supervisor did not stop after quitting")
+	}
+}
+
+func TestSupervisorBacksOff(t *testing.T) {
+	s := newTestIrcServer(t, "")
+	n := newTestNetwork(t, s, &Config{ReconnectMin: 10 * time.Millisecond, ReconnectMax: 20 * time.Millisecond})
+	s.close()
+
+	go n.supervisor.Run()
+	t.Cleanup(n.irccon.Quit)
+
+	assert.Eventually(t, func() bool {
+		return n.supervisor.Status().Attempts >= 3
+	}, 5*time.Second, 10*time.Millisecond)
+
+	status := n.supervisor.Status()
+	assert.NotEqual(t, stateConnected, status.State)
+	assert.NotEmpty(t, status.LastError)
+}
+
+func TestSupervisorGivesUpWithoutDialling(t *testing.T) {
+	irccon := &ircevent.Connection{Server: "127.0.0.1:1", Nick: "gowon", KeepAlive: time.Second, Timeout: time.Minute}
+	s := NewSupervisor(defaultNetwork, irccon, &Config{})
+
+	s.Run()
+
+	assert.Equal(t, 0, s.Status().Attempts)
+	assert.Equal(t, "KeepAlive must be at least Timeout", s.Status().LastError)
+}